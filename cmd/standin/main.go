@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"nuchal-api/model"
+	"nuchal-api/standin"
+	"os"
+	"strings"
+	"time"
+)
+
+func init() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+}
+
+// main runs a local Coinbase Pro stand-in, point an Api Rest at http://<addr> and Feed at ws://<addr>.
+func main() {
+
+	addr := flag.String("addr", "localhost:9090", "listen address")
+	products := flag.String("products", "", "comma separated products to replay from stored rates")
+	csvPath := flag.String("csv", "", "csv of product_id,unix_second,low,high,open,close,volume to replay")
+	hours := flag.Int("hours", 24, "hours of stored rates to replay, ending now")
+	interval := flag.Duration("interval", time.Second, "delay between replayed ticks")
+	cash := flag.Float64("cash", 10000, "starting USD balance")
+	fee := flag.Float64("fee", 0.005, "maker and taker fee")
+	flag.Parse()
+
+	exchange := model.NewFakeExchange(*fee, *fee)
+	exchange.Deposit("USD", *cash)
+
+	server := standin.New(exchange)
+
+	if *products != "" {
+		omega := time.Now().UTC()
+		alpha := omega.Add(time.Duration(-*hours) * time.Hour)
+		for _, productID := range strings.Split(*products, ",") {
+			server.Load(standin.NewProduct(productID), standin.FromRates(productID, alpha.Unix(), omega.Unix()))
+		}
+	}
+
+	if *csvPath != "" {
+		f, err := os.Open(*csvPath)
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		rates, err := standin.FromCSV(f)
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		for productID, productRates := range rates {
+			server.Load(standin.NewProduct(productID), productRates)
+		}
+	}
+
+	go server.Replay(*interval)
+
+	log.Info().Str("addr", *addr).Msg("standin listening")
+
+	if err := http.ListenAndServe(*addr, server.Handler()); err != nil {
+		log.Fatal().Err(err).Send()
+	}
+}
@@ -0,0 +1,76 @@
+package indicator
+
+// SMAStream is the simple moving average of the last n values.
+type SMAStream struct {
+	n      int
+	window []float64
+	sum    float64
+}
+
+func NewSMAStream(n int) *SMAStream {
+	return &SMAStream{n: n}
+}
+
+// Next adds a value, returning the average once n values have been added.
+func (s *SMAStream) Next(v float64) (float64, bool) {
+	s.window = append(s.window, v)
+	s.sum += v
+	if len(s.window) > s.n {
+		s.sum -= s.window[0]
+		s.window = s.window[1:]
+	}
+	if len(s.window) < s.n {
+		return 0, false
+	}
+	return s.sum / float64(s.n), true
+}
+
+// SMA is the simple moving average of every n values.
+func SMA(values []float64, n int) []float64 {
+	return series(values, NewSMAStream(n).Next)
+}
+
+// EMAStream is the exponential moving average of period n, seeded with the simple average of the first n values.
+type EMAStream struct {
+	n     int
+	k     float64
+	seed  *SMAStream
+	value float64
+	ready bool
+}
+
+func NewEMAStream(n int) *EMAStream {
+	return &EMAStream{n: n, k: 2 / float64(n+1), seed: NewSMAStream(n)}
+}
+
+// Next adds a value, returning the average once n values have been added.
+func (e *EMAStream) Next(v float64) (float64, bool) {
+	if !e.ready {
+		e.value, e.ready = e.seed.Next(v)
+		return e.value, e.ready
+	}
+	e.value += e.k * (v - e.value)
+	return e.value, true
+}
+
+// EMA is the exponential moving average of period n.
+func EMA(values []float64, n int) []float64 {
+	return series(values, NewEMAStream(n).Next)
+}
+
+// wilder is the running average Wilder uses for RSI and ATR, seeded with the simple average of the first n values.
+type wilder struct {
+	n     int
+	count int
+	value float64
+}
+
+func (w *wilder) next(v float64) (float64, bool) {
+	w.count++
+	if w.count <= w.n {
+		w.value += v / float64(w.n)
+		return w.value, w.count == w.n
+	}
+	w.value = (w.value*float64(w.n-1) + v) / float64(w.n)
+	return w.value, true
+}
@@ -0,0 +1,54 @@
+// Package indicator computes technical indicators over candles, in batch over a series or one candle at a time
+// as candles close. Batch results line up with their input, NaN until an indicator has enough candles.
+package indicator
+
+import "math"
+
+// Candle is the part of a rate the indicators read.
+type Candle struct {
+	Open   float64 `json:"open"`
+	High   float64 `json:"high"`
+	Low    float64 `json:"low"`
+	Close  float64 `json:"close"`
+	Volume float64 `json:"volume"`
+}
+
+// Closes returns the close of every candle.
+func Closes(candles []Candle) []float64 {
+	closes := make([]float64, len(candles))
+	for i, c := range candles {
+		closes[i] = c.Close
+	}
+	return closes
+}
+
+// typical is the average of the high, low and close.
+func (c Candle) typical() float64 {
+	return (c.High + c.Low + c.Close) / 3
+}
+
+// series runs a stream over values, NaN where it is not ready.
+func series(values []float64, next func(float64) (float64, bool)) []float64 {
+	results := make([]float64, len(values))
+	for i, v := range values {
+		if x, ok := next(v); ok {
+			results[i] = x
+		} else {
+			results[i] = math.NaN()
+		}
+	}
+	return results
+}
+
+// candleSeries runs a stream over candles, NaN where it is not ready.
+func candleSeries(candles []Candle, next func(Candle) (float64, bool)) []float64 {
+	results := make([]float64, len(candles))
+	for i, c := range candles {
+		if x, ok := next(c); ok {
+			results[i] = x
+		} else {
+			results[i] = math.NaN()
+		}
+	}
+	return results
+}
@@ -0,0 +1,117 @@
+package indicator
+
+import (
+	"math"
+	"nuchal-api/util"
+	"testing"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestAverages(t *testing.T) {
+
+	values := []float64{1, 2, 3, 4, 5, 6}
+
+	sma := SMA(values, 3)
+	if !math.IsNaN(sma[1]) || !near(sma[2], 2) || !near(sma[5], 5) {
+		t.Fail()
+	}
+
+	ema := EMA(values, 3)
+	if !math.IsNaN(ema[1]) || !near(ema[2], 2) || !near(ema[3], 3) || !near(ema[5], 5) {
+		t.Fail()
+	}
+
+	util.PrettyPrint(ema)
+}
+
+func TestRSI(t *testing.T) {
+
+	rising := RSI([]float64{1, 2, 3, 4, 5}, 3)
+	if !math.IsNaN(rising[2]) || !near(rising[3], 100) {
+		t.Fail()
+	}
+
+	// gains of 2 and 1 against a loss of 1 average to 1 and 1/3
+	rsi := RSI([]float64{10, 12, 11, 12}, 3)
+	if !near(rsi[3], 75) {
+		t.Fail()
+	}
+
+	util.PrettyPrint(rsi)
+}
+
+func TestMACD(t *testing.T) {
+
+	var values []float64
+	for i := 0; i < 40; i++ {
+		values = append(values, float64(i))
+	}
+
+	macd, signal, hist := MACD(values, 12, 26, 9)
+	if !math.IsNaN(macd[32]) || math.IsNaN(macd[33]) {
+		t.Fail()
+	}
+
+	// a straight line keeps every EMA its lag behind, so the MACD settles at the difference of the lags
+	if !near(macd[39], 7) || !near(signal[39], 7) || !near(hist[39], 0) {
+		t.Fail()
+	}
+
+	util.PrettyPrint(macd[33:])
+}
+
+func TestBollinger(t *testing.T) {
+
+	middle, upper, lower := Bollinger([]float64{2, 4, 4, 4, 5, 5, 7, 9}, 8, 2)
+	if !math.IsNaN(middle[6]) || !near(middle[7], 5) || !near(upper[7], 9) || !near(lower[7], 1) {
+		t.Fail()
+	}
+}
+
+func TestATR(t *testing.T) {
+
+	candles := []Candle{
+		{High: 10, Low: 8, Close: 9},
+		{High: 12, Low: 10, Close: 11},
+		{High: 11, Low: 6, Close: 7},
+		{High: 8, Low: 7, Close: 8},
+	}
+
+	// true ranges are 2, 3, 5 and 1
+	atr := ATR(candles, 3)
+	if !math.IsNaN(atr[1]) || !near(atr[2], 10.0/3) || !near(atr[3], (10.0/3*2+1)/3) {
+		t.Fail()
+	}
+
+	util.PrettyPrint(atr)
+}
+
+func TestVolume(t *testing.T) {
+
+	candles := []Candle{
+		{High: 3, Low: 1, Close: 2, Volume: 1},
+		{High: 5, Low: 3, Close: 4, Volume: 3},
+		{High: 4, Low: 2, Close: 3, Volume: 2},
+		{High: 4, Low: 2, Close: 3, Volume: 5},
+	}
+
+	vwap := VWAP(candles)
+	if !near(vwap[0], 2) || !near(vwap[1], 3.5) || !near(vwap[3], 35.0/11) {
+		t.Fail()
+	}
+
+	obv := OBV(candles)
+	if obv[0] != 0 || obv[1] != 3 || obv[2] != 1 || obv[3] != 1 {
+		t.Fail()
+	}
+
+	s := NewVWAPStream()
+	s.Next(candles[0])
+	s.Reset()
+	if v, ok := s.Next(candles[1]); !ok || v != 4 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,101 @@
+package indicator
+
+import "math"
+
+// RSIStream is the relative strength index of period n with Wilder's smoothing.
+type RSIStream struct {
+	gain, loss wilder
+	last       float64
+	started    bool
+}
+
+func NewRSIStream(n int) *RSIStream {
+	return &RSIStream{gain: wilder{n: n}, loss: wilder{n: n}}
+}
+
+// Next adds a close, returning the index once n changes have been seen.
+func (r *RSIStream) Next(v float64) (float64, bool) {
+
+	if !r.started {
+		r.last, r.started = v, true
+		return 0, false
+	}
+
+	change := v - r.last
+	r.last = v
+
+	var up, down float64
+	if change > 0 {
+		up = change
+	} else {
+		down = -change
+	}
+
+	gain, ok := r.gain.next(up)
+	loss, _ := r.loss.next(down)
+	if !ok {
+		return 0, false
+	}
+
+	if loss == 0 {
+		return 100, true
+	}
+
+	return 100 - 100/(1+gain/loss), true
+}
+
+// RSI is the relative strength index of period n.
+func RSI(values []float64, n int) []float64 {
+	return series(values, NewRSIStream(n).Next)
+}
+
+// MACDValue is the MACD line, its signal line and their difference.
+type MACDValue struct {
+	MACD      float64 `json:"macd"`
+	Signal    float64 `json:"signal"`
+	Histogram float64 `json:"histogram"`
+}
+
+// MACDStream is the difference of a fast and slow EMA, with an EMA of that difference as its signal.
+type MACDStream struct {
+	fast, slow, signal *EMAStream
+}
+
+func NewMACDStream(fast, slow, signal int) *MACDStream {
+	return &MACDStream{NewEMAStream(fast), NewEMAStream(slow), NewEMAStream(signal)}
+}
+
+// Next adds a close, returning the value once the signal line has enough MACD values.
+func (m *MACDStream) Next(v float64) (MACDValue, bool) {
+
+	fast, _ := m.fast.Next(v)
+	slow, ok := m.slow.Next(v)
+	if !ok {
+		return MACDValue{}, false
+	}
+
+	macd := fast - slow
+	signal, ok := m.signal.Next(macd)
+	if !ok {
+		return MACDValue{MACD: macd}, false
+	}
+
+	return MACDValue{macd, signal, macd - signal}, true
+}
+
+// MACD is the MACD, signal and histogram series, the usual periods being 12, 26 and 9.
+func MACD(values []float64, fast, slow, signal int) (macd, sig, hist []float64) {
+	s := NewMACDStream(fast, slow, signal)
+	macd = series(values, func(v float64) (float64, bool) {
+		value, ok := s.Next(v)
+		sig = append(sig, value.Signal)
+		hist = append(hist, value.Histogram)
+		return value.MACD, ok
+	})
+	for i := range macd {
+		if math.IsNaN(macd[i]) {
+			sig[i], hist[i] = macd[i], macd[i]
+		}
+	}
+	return
+}
@@ -0,0 +1,81 @@
+package indicator
+
+import "math"
+
+// Band is a Bollinger band around a moving average.
+type Band struct {
+	Middle float64 `json:"middle"`
+	Upper  float64 `json:"upper"`
+	Lower  float64 `json:"lower"`
+}
+
+// BollingerStream is the simple moving average of n values, banded k standard deviations either side.
+type BollingerStream struct {
+	k   float64
+	sma *SMAStream
+}
+
+func NewBollingerStream(n int, k float64) *BollingerStream {
+	return &BollingerStream{k: k, sma: NewSMAStream(n)}
+}
+
+// Next adds a close, returning the band once n closes have been added.
+func (b *BollingerStream) Next(v float64) (Band, bool) {
+
+	mean, ok := b.sma.Next(v)
+	if !ok {
+		return Band{}, false
+	}
+
+	var variance float64
+	for _, x := range b.sma.window {
+		variance += (x - mean) * (x - mean)
+	}
+	deviation := math.Sqrt(variance / float64(b.sma.n))
+
+	return Band{mean, mean + b.k*deviation, mean - b.k*deviation}, true
+}
+
+// Bollinger is the middle, upper and lower band series, usually of 20 closes and 2 deviations.
+func Bollinger(values []float64, n int, k float64) (middle, upper, lower []float64) {
+	s := NewBollingerStream(n, k)
+	for _, v := range values {
+		band, ok := s.Next(v)
+		if !ok {
+			band = Band{math.NaN(), math.NaN(), math.NaN()}
+		}
+		middle = append(middle, band.Middle)
+		upper = append(upper, band.Upper)
+		lower = append(lower, band.Lower)
+	}
+	return
+}
+
+// ATRStream is the average true range of period n with Wilder's smoothing.
+type ATRStream struct {
+	average wilder
+	last    float64
+	started bool
+}
+
+func NewATRStream(n int) *ATRStream {
+	return &ATRStream{average: wilder{n: n}}
+}
+
+// Next adds a candle, returning the range once n candles have been added.
+func (a *ATRStream) Next(c Candle) (float64, bool) {
+
+	tr := c.High - c.Low
+	if a.started {
+		tr = math.Max(tr, math.Max(math.Abs(c.High-a.last), math.Abs(c.Low-a.last)))
+	}
+
+	a.last, a.started = c.Close, true
+
+	return a.average.next(tr)
+}
+
+// ATR is the average true range of period n.
+func ATR(candles []Candle, n int) []float64 {
+	return candleSeries(candles, NewATRStream(n).Next)
+}
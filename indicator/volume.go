@@ -0,0 +1,58 @@
+package indicator
+
+// VWAPStream is the volume weighted average typical price since the stream started or was last reset.
+type VWAPStream struct {
+	value, volume float64
+}
+
+func NewVWAPStream() *VWAPStream {
+	return &VWAPStream{}
+}
+
+// Next adds a candle, returning the average once any volume has traded.
+func (v *VWAPStream) Next(c Candle) (float64, bool) {
+	v.value += c.typical() * c.Volume
+	v.volume += c.Volume
+	if v.volume == 0 {
+		return 0, false
+	}
+	return v.value / v.volume, true
+}
+
+// Reset starts a new session, such as a new day.
+func (v *VWAPStream) Reset() {
+	v.value, v.volume = 0, 0
+}
+
+// VWAP is the volume weighted average price over the whole series.
+func VWAP(candles []Candle) []float64 {
+	return candleSeries(candles, NewVWAPStream().Next)
+}
+
+// OBVStream is the on balance volume, adding the volume of candles closing up and subtracting that of those closing down.
+type OBVStream struct {
+	value, last float64
+	started     bool
+}
+
+func NewOBVStream() *OBVStream {
+	return &OBVStream{}
+}
+
+// Next adds a candle, returning the running total, which starts at zero.
+func (o *OBVStream) Next(c Candle) (float64, bool) {
+	if o.started {
+		if c.Close > o.last {
+			o.value += c.Volume
+		} else if c.Close < o.last {
+			o.value -= c.Volume
+		}
+	}
+	o.last, o.started = c.Close, true
+	return o.value, true
+}
+
+// OBV is the on balance volume series.
+func OBV(candles []Candle) []float64 {
+	return candleSeries(candles, NewOBVStream().Next)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"github.com/gin-gonic/gin"
+	ws "github.com/gorilla/websocket"
 	cb "github.com/preichenberger/go-coinbasepro/v2"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -12,6 +13,7 @@ import (
 	"nuchal-api/util"
 	"os"
 	"strconv"
+	"time"
 )
 
 func init() {
@@ -75,6 +77,10 @@ func main() {
 	router.GET("/patterns/:userID", getPatterns)
 	router.GET("/pattern/:patternID", getPattern)
 	router.DELETE("/pattern/:patternID", deletePattern)
+	router.GET("/pattern/:patternID/revisions", getPatternRevisions)
+	router.GET("/pattern/:patternID/revisions/:from/:to", diffPatternRevisions)
+	router.POST("/pattern/:patternID/revision/:revision/restore", restorePatternRevision)
+	router.GET("/formations", getFormations)
 
 	/*
 		order
@@ -88,6 +94,11 @@ func main() {
 	*/
 	router.GET("/chart/product/:userID/:productID/:alpha/:omega", getProductChart)
 
+	/*
+		rates
+	*/
+	router.POST("/rates/backfill/:userID/:productID/:alpha/:omega", backfillRates)
+
 	/*
 		session
 	*/
@@ -98,17 +109,220 @@ func main() {
 	router.POST("/session/sell/:price/:size/:productID", startSellSession)
 	router.DELETE("/session/sell/:ID", deleteSellSession)
 	router.DELETE("/session/buy/:ID", deleteBuySession)
+	router.GET("/session/sell/:ID/ticks", getSellSessionTicks)
+	router.GET("/session/sell/:ID/replay", replaySellSession)
+
+	/*
+		ticks
+	*/
+	router.GET("/ticks/:productID/:alpha/:omega", getTicks)
+
+	/*
+		book
+	*/
+	router.GET("/book/:productID", getBook)
+
+	/*
+		stream
+	*/
+	router.GET("/stream/:productID", streamProduct)
+	router.GET("/events/:userID", streamEvents)
 
 	/*
 		history
 	*/
 	router.GET("/history/:userID", getHistory)
 
+	/*
+		scheduler
+	*/
+	router.GET("/scheduler", getScheduler)
+	router.POST("/scheduler/start", startScheduler)
+	router.POST("/scheduler/stop", stopScheduler)
+
+	if err := model.BootScheduler(); err != nil {
+		log.Err(err).Msg("starting scheduler")
+	}
+
 	router.Run("localhost:9080")
 }
 
 /*
-	history
+ticks
+*/
+func getTicks(c *gin.Context) {
+	alpha := time.Unix(util.StringToInt64(c.Param("alpha")), 0)
+	omega := time.Unix(util.StringToInt64(c.Param("omega")), 0)
+	c.IndentedJSON(http.StatusOK, model.FindTicks(c.Param("productID"), alpha, omega))
+}
+
+func getSellSessionTicks(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, model.FindSellSessionTicks(util.StringToUint(c.Param("ID"))))
+}
+
+/*
+stream
+*/
+var upgrader = ws.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+func streamProduct(c *gin.Context) {
+	streamTo(c, func(send func(model.StreamMessage) error, done <-chan struct{}) error {
+		return model.Stream(c.Param("productID"), send, done)
+	})
+}
+
+// replaySellSession streams the recorded ticks of a sell session at ?speed= times the pace they were received.
+func replaySellSession(c *gin.Context) {
+
+	speed := 1.0
+	if v := c.Query("speed"); v != "" {
+		speed = util.StringToFloat64(v)
+	}
+
+	streamTo(c, func(send func(model.StreamMessage) error, done <-chan struct{}) error {
+		return model.ReplaySellSession(util.StringToUint(c.Param("ID")), speed, send, done)
+	})
+}
+
+// streamTo upgrades the request to a websocket and runs a stream over it until the client goes away.
+func streamTo(c *gin.Context, run func(send func(model.StreamMessage) error, done <-chan struct{}) error) {
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Err(err).Send()
+		return
+	}
+
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Err(err).Send()
+		}
+	}()
+
+	// the client only reads, a failed read means it went away
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(msg model.StreamMessage) error {
+		return conn.WriteJSON(msg)
+	}
+
+	if err = run(send, done); err != nil {
+		log.Err(err).Str("path", c.Request.URL.Path).Msg("streaming")
+	}
+}
+
+func streamEvents(c *gin.Context) {
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Err(err).Send()
+		return
+	}
+
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Err(err).Send()
+		}
+	}()
+
+	listener := model.Listen(userID(c))
+	defer listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case event := <-listener.Events:
+			if err = conn.WriteJSON(event); err != nil {
+				log.Err(err).Msg("streaming events")
+				return
+			}
+		}
+	}
+}
+
+/*
+book
+*/
+func getBook(c *gin.Context) {
+
+	levels, err := strconv.Atoi(c.DefaultQuery("levels", "50"))
+	if err != nil || levels < 1 {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	depth, err := model.GetDepth(c.Param("productID"), levels)
+	if err != nil {
+		log.Err(err).Send()
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, depth)
+}
+
+/*
+scheduler
+*/
+func getScheduler(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, model.GetSchedulerStatus())
+}
+
+func startScheduler(c *gin.Context) {
+
+	var interval time.Duration
+	if s := c.Query("interval"); s != "" {
+		var err error
+		if interval, err = time.ParseDuration(s); err != nil {
+			log.Err(err).Stack().Send()
+			c.Status(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := model.StartScheduler(interval, c.QueryArray("product")); err != nil {
+		log.Err(err).Send()
+		c.Status(http.StatusConflict)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, model.GetSchedulerStatus())
+}
+
+func stopScheduler(c *gin.Context) {
+	if err := model.StopScheduler(); err != nil {
+		log.Err(err).Send()
+		c.Status(http.StatusConflict)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, model.GetSchedulerStatus())
+}
+
+/*
+history
 */
 func getHistory(c *gin.Context) {
 	his, err := model.GetHistory(userID(c))
@@ -121,7 +335,7 @@ func getHistory(c *gin.Context) {
 }
 
 /*
-	sessions
+sessions
 */
 func getSessions(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, model.GetSessions(userID(c)))
@@ -140,7 +354,9 @@ func disableBuySession(c *gin.Context) {
 }
 
 func enableBuySession(c *gin.Context) {
-	model.EnableBuySession(util.StringToUint(c.Param("ID")))
+	if err := model.EnableBuySession(util.StringToUint(c.Param("ID"))); err != nil {
+		invalidPattern(c, err)
+	}
 }
 
 func startSellSession(c *gin.Context) {
@@ -155,19 +371,38 @@ func startSellSession(c *gin.Context) {
 }
 
 func startBuySession(c *gin.Context) {
-	model.StartBuySession(util.StringToUint(c.Param("patternID")))
+	if err := model.StartBuySession(util.StringToUint(c.Param("patternID"))); err != nil {
+		invalidPattern(c, err)
+		return
+	}
 	c.Status(http.StatusOK)
 }
 
+// invalidPattern answers a pattern that failed validation with its invalid fields.
+func invalidPattern(c *gin.Context, err error) {
+	if validationErr, ok := err.(*model.ValidationError); ok {
+		c.IndentedJSON(http.StatusBadRequest, validationErr)
+		return
+	}
+	log.Err(err).Stack().Send()
+	c.Status(http.StatusInternalServerError)
+}
+
 func getProductChart(c *gin.Context) {
 
 	alpha := util.StringToInt64(c.Param("alpha"))
 	omega := util.StringToInt64(c.Param("omega"))
 
+	granularity, err := model.ParseGranularity(c.DefaultQuery("granularity", "60"))
+	if err != nil {
+		log.Err(err).Stack().Send()
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
 	var chart model.Chart
-	var err error
 
-	if chart, err = model.NewProductChart(userID(c), c.Param("productID"), alpha, omega); err != nil {
+	if chart, err = model.NewProductChart(c.Param("productID"), alpha, omega, granularity); err != nil {
 		c.Status(400)
 		return
 	}
@@ -175,6 +410,28 @@ func getProductChart(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, chart)
 }
 
+func backfillRates(c *gin.Context) {
+
+	alpha := util.StringToInt64(c.Param("alpha"))
+	omega := util.StringToInt64(c.Param("omega"))
+
+	granularity, err := model.ParseGranularity(c.DefaultQuery("granularity", "60"))
+	if err != nil {
+		log.Err(err).Stack().Send()
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	backfill, err := model.BackfillRates(c.Param("productID"), alpha, omega, granularity)
+	if err != nil {
+		log.Err(err).Stack().Send()
+		c.IndentedJSON(http.StatusBadGateway, backfill)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, backfill)
+}
+
 func deleteOrder(c *gin.Context) {
 	if err := model.DeleteOrder(userID(c), c.Param("orderID")); err != nil {
 		log.Err(err).Stack().Send()
@@ -228,8 +485,56 @@ func getPattern(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, model.FindPattern(uint(patternID)))
 }
 
+func getPatternRevisions(c *gin.Context) {
+	revisions, err := model.GetPatternRevisions(util.StringToUint(c.Param("patternID")))
+	if err != nil {
+		log.Err(err).Stack().Send()
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, revisions)
+}
+
+func diffPatternRevisions(c *gin.Context) {
+	from, err := strconv.Atoi(c.Param("from"))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	to, err := strconv.Atoi(c.Param("to"))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	changes, err := model.DiffPatternRevisions(util.StringToUint(c.Param("patternID")), from, to)
+	if err != nil {
+		log.Err(err).Stack().Send()
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, changes)
+}
+
+func restorePatternRevision(c *gin.Context) {
+	revision, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	pattern, err := model.RestorePatternRevision(util.StringToUint(c.Param("patternID")), revision)
+	if _, ok := err.(*model.ValidationError); ok {
+		invalidPattern(c, err)
+		return
+	} else if err != nil {
+		log.Err(err).Stack().Send()
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, pattern)
+}
+
 /*
-	portfolio
+portfolio
 */
 func getPortfolio(c *gin.Context) {
 	portfolio, err := model.GetPortfolio(userID(c))
@@ -269,8 +574,22 @@ func getPatternSim(c *gin.Context) {
 		return
 	}
 
+	granularity, err := model.ParseGranularity(c.DefaultQuery("granularity", "60"))
+	if err != nil {
+		log.Err(err).Stack().Send()
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	revision, err := strconv.Atoi(c.DefaultQuery("revision", "0"))
+	if err != nil {
+		log.Err(err).Stack().Send()
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
 	var sim model.Sim
-	sim, err = model.NewSim(uint(patternID), alpha, omega)
+	sim, err = model.NewSim(uint(patternID), revision, alpha, omega, granularity)
 	if err != nil {
 		log.Err(err).Stack().Send()
 		c.Status(http.StatusBadRequest)
@@ -280,6 +599,10 @@ func getPatternSim(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, sim)
 }
 
+func getFormations(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, model.Formations())
+}
+
 func deletePattern(c *gin.Context) {
 	patternID, err := strconv.Atoi(c.Param("patternID"))
 	if err != nil {
@@ -306,7 +629,15 @@ func savePattern(c *gin.Context) {
 		log.Err(err).Stack().Send()
 		c.Status(http.StatusBadRequest)
 	}
-	p.Save()
+	if err := p.Validate(); err != nil {
+		invalidPattern(c, err)
+		return
+	}
+	if err := p.Save(); err != nil {
+		log.Err(err).Stack().Send()
+		c.Status(http.StatusInternalServerError)
+		return
+	}
 	c.IndentedJSON(http.StatusOK, model.FindPatternByID(p.ID))
 }
 
@@ -331,7 +662,18 @@ func saveUser(c *gin.Context) {
 		return
 	}
 
-	c.IndentedJSON(http.StatusOK, model.SaveUser(u))
+	saved, err := model.SaveUser(u)
+	if err != nil {
+		if fieldErr, ok := err.(*model.FieldError); ok {
+			c.IndentedJSON(http.StatusBadRequest, fieldErr)
+			return
+		}
+		log.Err(err).Stack().Send()
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, saved)
 }
 
 func getUsers(c *gin.Context) {
@@ -0,0 +1,287 @@
+package model
+
+import (
+	"errors"
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"github.com/rs/zerolog/log"
+	"nuchal-api/db"
+	"strings"
+	"time"
+)
+
+// Gap is a run of candles missing from the rate store, from the candle starting at Alpha through the one at Omega.
+type Gap struct {
+	Alpha int64 `json:"alpha"`
+	Omega int64 `json:"omega"`
+}
+
+// Backfill reports what was missing between alpha and omega, what was fetched, and what the exchange had no data for.
+type Backfill struct {
+	ProductID   string      `json:"product_id"`
+	Granularity Granularity `json:"granularity"`
+	Alpha       int64       `json:"alpha"`
+	Omega       int64       `json:"omega"`
+	Gaps        []Gap       `json:"gaps"`
+	Requests    int         `json:"requests"`
+	Fetched     int         `json:"fetched"`
+	Empty       []Gap       `json:"empty"`
+}
+
+// EmptyRange is a run of candles the exchange has no data for, usually minutes without trades, remembered so later
+// backfills don't ask for it again.
+type EmptyRange struct {
+	ID          uint        `json:"-" gorm:"primarykey"`
+	ProductID   string      `json:"product_id" gorm:"index:idx_empty_range,priority:1"`
+	Granularity Granularity `json:"granularity" gorm:"index:idx_empty_range,priority:2"`
+	Alpha       int64       `json:"alpha" gorm:"index:idx_empty_range,priority:3"`
+	Omega       int64       `json:"omega"`
+}
+
+const (
+	// requestInterval keeps backfills under the exchange's public rate limit of 3 requests a second.
+	requestInterval = time.Second / 3
+
+	// maxRateLimitRetries is how many times a rate limited request is retried before the backfill fails.
+	maxRateLimitRetries = 5
+
+	// emptySettle is how long the exchange may take to publish a candle, only older ranges are remembered as empty.
+	emptySettle = 10 * time.Minute
+)
+
+var throttle = time.NewTicker(requestInterval)
+
+func init() {
+	db.Migrate(&EmptyRange{})
+}
+
+func (g Gap) candles(granularity Granularity) int64 {
+	return (g.Omega-g.Alpha)/int64(granularity) + 1
+}
+
+// BackfillRates fetches every candle missing from the rate store between alpha and omega from the public market.
+func BackfillRates(productID string, alpha, omega int64, granularity Granularity) (Backfill, error) {
+
+	backfill := Backfill{
+		ProductID:   productID,
+		Granularity: granularity,
+		Alpha:       alpha,
+		Omega:       omega,
+		Gaps:        findGaps(productID, alpha, omega, granularity),
+	}
+
+	if len(backfill.Gaps) == 0 {
+		return backfill, nil
+	}
+
+	client := marketData()
+
+	for _, params := range pages(backfill.Gaps, granularity) {
+
+		out, err := getHistoricRates(client, productID, params)
+		backfill.Requests++
+		if err != nil {
+			log.Err(err).Str("productID", productID).Msg("backfilling rates")
+			return backfill, err
+		}
+
+		rates := make([]Rate, len(out))
+		for i, rate := range out {
+			rates[i] = newGranularRate(productID, rate, granularity)
+		}
+
+		if err = saveExchangeRates(rates); err != nil {
+			return backfill, err
+		}
+		backfill.Fetched += len(out)
+	}
+
+	// whatever is still missing is a range the exchange has no candles for, usually minutes without trades
+	backfill.Empty = findGaps(productID, alpha, omega, granularity)
+	saveEmptyRanges(productID, granularity, backfill.Empty)
+
+	log.Debug().
+		Str("productID", productID).
+		Int64("granularity", int64(granularity)).
+		Int("gaps", len(backfill.Gaps)).
+		Int("requests", backfill.Requests).
+		Int("fetched", backfill.Fetched).
+		Int("empty", len(backfill.Empty)).
+		Msg("backfilled rates")
+
+	return backfill, nil
+}
+
+// findGaps walks the candles of a granularity between alpha and the last closed candle before omega,
+// collecting runs that are not stored, or that were built from the feed and not yet reconciled, and that are not
+// known to be empty.
+func findGaps(productID string, alpha, omega int64, granularity Granularity) []Gap {
+
+	step := int64(granularity)
+
+	first := granularity.bucket(alpha + step - 1)
+	last := granularity.bucket(omega)
+	if open := granularity.bucket(time.Now().Unix()); last >= open {
+		last = open - step
+	}
+
+	if first > last {
+		return nil
+	}
+
+	var stored []int64
+	db.Resolve().
+		Model(&Rate{}).
+		Where("product_id = ?", productID).
+		Where("granularity = ?", granularity).
+		Where("unix_second BETWEEN ? AND ?", first, last).
+		Where("feed = ? OR reconciled = ?", false, true).
+		Order("unix_second asc").
+		Pluck("unix_second", &stored)
+
+	var empty []Gap
+	db.Resolve().
+		Model(&EmptyRange{}).
+		Where("product_id = ?", productID).
+		Where("granularity = ?", granularity).
+		Where("alpha <= ? AND omega >= ?", last, first).
+		Order("alpha asc").
+		Select("alpha, omega").
+		Scan(&empty)
+
+	return without(gaps(stored, first, last, granularity), empty, granularity)
+}
+
+// without removes the ascending empty runs from the gaps.
+func without(gaps, empty []Gap, granularity Granularity) []Gap {
+
+	var results []Gap
+
+	step := int64(granularity)
+
+	for _, gap := range gaps {
+		for _, e := range empty {
+			if e.Omega < gap.Alpha || e.Alpha > gap.Omega {
+				continue
+			}
+			if e.Alpha > gap.Alpha {
+				results = append(results, Gap{gap.Alpha, e.Alpha - step})
+			}
+			if gap.Alpha = e.Omega + step; gap.Alpha > gap.Omega {
+				break
+			}
+		}
+		if gap.Alpha <= gap.Omega {
+			results = append(results, gap)
+		}
+	}
+
+	return results
+}
+
+// saveEmptyRanges remembers the runs the exchange had no candles for, leaving out candles too recent to be sure of.
+func saveEmptyRanges(productID string, granularity Granularity, empty []Gap) {
+
+	settled := granularity.bucket(time.Now().Add(-emptySettle).Unix())
+
+	var ranges []EmptyRange
+	for _, gap := range empty {
+		if gap.Omega >= settled {
+			gap.Omega = settled - int64(granularity)
+		}
+		if gap.Alpha <= gap.Omega {
+			ranges = append(ranges, EmptyRange{ProductID: productID, Granularity: granularity, Alpha: gap.Alpha, Omega: gap.Omega})
+		}
+	}
+
+	if len(ranges) > 0 {
+		if err := db.Resolve().Create(&ranges).Error; err != nil {
+			log.Err(err).Str("productID", productID).Msg("saving empty ranges")
+		}
+	}
+}
+
+// gaps finds the runs of candles between first and last missing from the ascending stored unix seconds.
+func gaps(stored []int64, first, last int64, granularity Granularity) []Gap {
+
+	var results []Gap
+
+	step := int64(granularity)
+	next := first
+
+	for _, unixSecond := range stored {
+		if unixSecond < next {
+			continue
+		}
+		if unixSecond > next {
+			results = append(results, Gap{next, unixSecond - step})
+		}
+		next = unixSecond + step
+	}
+
+	if next <= last {
+		results = append(results, Gap{next, last})
+	}
+
+	return results
+}
+
+// pages covers the gaps with requests of at most maxCandles candles, packing nearby gaps into one request so
+// scattered holes, such as minutes without trades, don't cost a request each.
+func pages(gaps []Gap, granularity Granularity) []cb.GetHistoricRatesParams {
+
+	var results []cb.GetHistoricRatesParams
+
+	gaps = append([]Gap(nil), gaps...)
+
+	step := int64(granularity)
+	span := (maxCandles - 1) * step
+
+	for i := 0; i < len(gaps); {
+
+		start := gaps[i].Alpha
+		limit := start + span
+		end := start
+
+		// take in every later gap that starts inside this page
+		for i < len(gaps) && gaps[i].Alpha <= limit {
+			if gaps[i].Omega > limit {
+				// the rest of the gap starts the next page
+				end = limit
+				gaps[i].Alpha = limit + step
+				break
+			}
+			end = gaps[i].Omega
+			i++
+		}
+
+		results = append(results, cb.GetHistoricRatesParams{
+			Start:       time.Unix(start, 0).UTC(),
+			End:         time.Unix(end, 0).UTC(),
+			Granularity: int(granularity),
+		})
+	}
+
+	return results
+}
+
+// getHistoricRates waits its turn under the request throttle, backing off and retrying when rate limited.
+func getHistoricRates(client Market, productID string, params cb.GetHistoricRatesParams) ([]cb.HistoricRate, error) {
+	for attempt := 0; ; attempt++ {
+
+		<-throttle.C
+
+		out, err := client.GetHistoricRates(productID, params)
+		if err == nil || !isRateLimited(err) || attempt == maxRateLimitRetries {
+			return out, err
+		}
+
+		wait := backoff(attempt)
+		log.Warn().Err(err).Str("productID", productID).Dur("wait", wait).Msg("rate limited")
+		time.Sleep(wait)
+	}
+}
+
+func isRateLimited(err error) bool {
+	var cbErr cb.Error
+	return errors.As(err, &cbErr) && strings.Contains(strings.ToLower(cbErr.Message), "rate limit")
+}
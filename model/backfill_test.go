@@ -0,0 +1,41 @@
+package model
+
+import (
+	"nuchal-api/util"
+	"testing"
+)
+
+func TestBackfillRates(t *testing.T) {
+
+	backfill, err := BackfillRates(productID, alpha, omega, Minute)
+	if err != nil {
+		t.Fail()
+	}
+
+	util.PrettyPrint(backfill)
+}
+
+func TestPages(t *testing.T) {
+
+	found := gaps([]int64{120, 180, 600}, 0, 60*1000, Minute)
+	if len(found) != 3 || found[0] != (Gap{0, 60}) || found[1] != (Gap{240, 540}) || found[2] != (Gap{660, 60 * 1000}) {
+		t.Fail()
+	}
+
+	params := pages(found, Minute)
+	if len(params) != 4 || params[0].Start.Unix() != 0 || params[0].End.Unix() != 299*60 || params[3].End.Unix() != 60*1000 {
+		t.Fail()
+	}
+
+	util.PrettyPrint(params)
+}
+
+func TestWithout(t *testing.T) {
+
+	found := without([]Gap{{0, 600}, {900, 1200}}, []Gap{{0, 120}, {300, 360}, {540, 960}}, Minute)
+	if len(found) != 3 || found[0] != (Gap{180, 240}) || found[1] != (Gap{420, 480}) || found[2] != (Gap{1020, 1200}) {
+		t.Fail()
+	}
+
+	util.PrettyPrint(found)
+}
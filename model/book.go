@@ -0,0 +1,206 @@
+package model
+
+import (
+	"errors"
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Book is the level 2 order book of a product, kept from the feed's snapshot and updates.
+type Book struct {
+	mu        sync.RWMutex
+	productID string
+	bids      map[string]float64
+	asks      map[string]float64
+	ready     chan struct{}
+	updated   time.Time
+
+	// stale is set while the feed is down, until the snapshot of the new connection replaces the book.
+	stale bool
+}
+
+// Level is the size resting at a price, and the total size from the best price through it.
+type Level struct {
+	Price float64 `json:"price"`
+	Size  float64 `json:"size"`
+	Total float64 `json:"total"`
+}
+
+// Depth is a view of a Book: its spread and the first levels of each side, best price first.
+type Depth struct {
+	ProductID string    `json:"product_id"`
+	Bid       float64   `json:"bid"`
+	Ask       float64   `json:"ask"`
+	Spread    float64   `json:"spread"`
+	Mid       float64   `json:"mid"`
+	Bids      []Level   `json:"bids"`
+	Asks      []Level   `json:"asks"`
+	Time      time.Time `json:"time"`
+}
+
+// bookTimeout is how long a depth waits for the snapshot of a product not already being followed.
+const bookTimeout = 5 * time.Second
+
+var (
+	errBookEmpty = errors.New("order book is empty")
+	errBookStale = errors.New("order book is stale")
+)
+
+func newBook(productID string) *Book {
+	return &Book{
+		productID: productID,
+		bids:      map[string]float64{},
+		asks:      map[string]float64{},
+		ready:     make(chan struct{}),
+	}
+}
+
+// snapshot replaces the book, marking it ready the first time.
+func (b *Book) snapshot(msg cb.Message) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bids = map[string]float64{}
+	b.asks = map[string]float64{}
+
+	for _, e := range msg.Bids {
+		b.bids[e.Price] = parseFloat(e.Size)
+	}
+	for _, e := range msg.Asks {
+		b.asks[e.Price] = parseFloat(e.Size)
+	}
+
+	b.updated = time.Now()
+	b.stale = false
+
+	select {
+	case <-b.ready:
+	default:
+		close(b.ready)
+	}
+}
+
+// update applies the changes of an l2update, a size of zero removing the level.
+func (b *Book) update(msg cb.Message) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, change := range msg.Changes {
+		side := b.bids
+		if change.Side == "sell" {
+			side = b.asks
+		}
+		if size := parseFloat(change.Size); size == 0 {
+			delete(side, change.Price)
+		} else {
+			side[change.Price] = size
+		}
+	}
+
+	b.updated = msg.Time.Time()
+}
+
+// markStale flags the book as behind the market since its feed dropped.
+func (b *Book) markStale() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stale = true
+}
+
+// Depth returns the spread and up to n levels of each side with cumulative totals, failing while the book is stale.
+func (b *Book) Depth(n int) (Depth, error) {
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.stale {
+		return Depth{ProductID: b.productID, Time: b.updated}, errBookStale
+	}
+
+	depth := Depth{
+		ProductID: b.productID,
+		Bids:      levels(b.bids, n, true),
+		Asks:      levels(b.asks, n, false),
+		Time:      b.updated,
+	}
+
+	if len(depth.Bids) == 0 || len(depth.Asks) == 0 {
+		return depth, errBookEmpty
+	}
+
+	depth.Bid = depth.Bids[0].Price
+	depth.Ask = depth.Asks[0].Price
+	depth.Spread = depth.Ask - depth.Bid
+	depth.Mid = (depth.Ask + depth.Bid) / 2
+
+	return depth, nil
+}
+
+// Spread returns the best bid and ask.
+func (b *Book) Spread() (float64, float64, error) {
+	depth, err := b.Depth(1)
+	return depth.Bid, depth.Ask, err
+}
+
+func levels(side map[string]float64, n int, descending bool) []Level {
+
+	var results []Level
+	for price, size := range side {
+		results = append(results, Level{Price: parseFloat(price), Size: size})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if descending {
+			return results[i].Price > results[j].Price
+		}
+		return results[i].Price < results[j].Price
+	})
+
+	if n > 0 && len(results) > n {
+		results = results[:n]
+	}
+
+	var total float64
+	for i := range results {
+		total += results[i].Size
+		results[i].Total = total
+	}
+
+	return results
+}
+
+// GetDepth follows the order book of a product on the environment feed long enough to read n levels of it.
+func GetDepth(productID string, n int) (Depth, error) {
+	return HubFor(environment.Feed).depth(productID, n)
+}
+
+// depth follows the order book of a product long enough to read n levels of it.
+func (h *Hub) depth(productID string, n int) (Depth, error) {
+
+	sub, err := h.SubscribeBook(productID)
+	if err != nil {
+		return Depth{}, err
+	}
+
+	defer func() {
+		if err := sub.Close(); err != nil {
+			h.log().Err(err).Send()
+		}
+	}()
+
+	book := h.Book(productID)
+
+	select {
+	case <-book.ready:
+	case <-sub.Lost:
+		return Depth{}, errPipeLost
+	case <-time.After(bookTimeout):
+		return Depth{}, errors.New("timed out waiting for the order book of " + productID)
+	}
+
+	return book.Depth(n)
+}
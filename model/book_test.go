@@ -0,0 +1,89 @@
+package model
+
+import (
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"nuchal-api/util"
+	"testing"
+	"time"
+)
+
+func TestBook(t *testing.T) {
+
+	book := newBook(productID)
+
+	if _, _, err := book.Spread(); err != errBookEmpty {
+		t.Fail()
+	}
+
+	book.snapshot(cb.Message{
+		Bids: []cb.SnapshotEntry{{Price: "9", Size: "1"}, {Price: "10", Size: "2"}},
+		Asks: []cb.SnapshotEntry{{Price: "12", Size: "1"}, {Price: "11", Size: "3"}},
+	})
+
+	book.update(cb.Message{Changes: []cb.SnapshotChange{
+		{Side: "sell", Price: "11", Size: "0"},
+		{Side: "buy", Price: "8", Size: "4"},
+	}})
+
+	depth, err := book.Depth(2)
+	if err != nil || depth.Bid != 10 || depth.Ask != 12 || depth.Spread != 2 || len(depth.Bids) != 2 || depth.Bids[1].Total != 3 || len(depth.Asks) != 1 {
+		t.Fail()
+	}
+
+	util.PrettyPrint(depth)
+}
+
+func TestBookStale(t *testing.T) {
+
+	book := newBook(productID)
+	book.snapshot(cb.Message{
+		Bids: []cb.SnapshotEntry{{Price: "10", Size: "1"}},
+		Asks: []cb.SnapshotEntry{{Price: "11", Size: "1"}},
+	})
+
+	book.markStale()
+	if _, _, err := book.Spread(); err != errBookStale {
+		t.Fail()
+	}
+
+	// the snapshot of the new connection makes it current again
+	book.snapshot(cb.Message{
+		Bids: []cb.SnapshotEntry{{Price: "10", Size: "1"}},
+		Asks: []cb.SnapshotEntry{{Price: "12", Size: "1"}},
+	})
+	if _, ask, err := book.Spread(); err != nil || ask != 12 {
+		t.Fail()
+	}
+}
+
+func TestGetSpread(t *testing.T) {
+
+	// while reconnecting a Hub subscribes without writing to the feed
+	h := &Hub{subs: map[string]map[*Subscription]bool{}, candles: map[string]*candle{}, closed: map[string]time.Time{}, books: map[string]*Book{}, reconnecting: true}
+
+	sub, _ := h.SubscribeBook(productID)
+	pipe := &Pipe{sub: sub, productID: productID, book: true}
+
+	// it does not wait for the snapshot
+	if _, _, err := pipe.getSpread(); err != errBookEmpty {
+		t.Fail()
+	}
+
+	h.followed(productID).snapshot(cb.Message{
+		Bids: []cb.SnapshotEntry{{Price: "10", Size: "1"}},
+		Asks: []cb.SnapshotEntry{{Price: "11", Size: "1"}},
+	})
+
+	if bid, ask, err := pipe.getSpread(); err != nil || bid != 10 || ask != 11 {
+		t.Fail()
+	}
+
+	// a pipe that does not follow the book has no spread
+	if _, _, err := (&Pipe{sub: sub, productID: productID}).getSpread(); err != errBookEmpty {
+		t.Fail()
+	}
+
+	if err := sub.Close(); err != nil {
+		t.Fail()
+	}
+}
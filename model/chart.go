@@ -26,10 +26,10 @@ type Settings struct {
 	LineWidth float64 `json:"line_width;omitempty"`
 }
 
-func NewProductChart(userID uint, productID string, alpha, omega int64) (chart Chart, err error) {
+func NewProductChart(productID string, alpha, omega int64, granularity Granularity) (chart Chart, err error) {
 
 	var rates []Rate
-	if rates, err = GetRates(userID, productID, alpha, omega); err != nil {
+	if rates, err = GetRates(productID, alpha, omega, granularity); err != nil {
 		return
 	}
 
@@ -7,7 +7,7 @@ import (
 
 func TestNewProductChart(t *testing.T) {
 
-	chart, err := NewProductChart(userID, productID, alpha, omega)
+	chart, err := NewProductChart(productID, alpha, omega, Minute)
 	if err != nil {
 		t.Fail()
 	}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"github.com/joho/godotenv"
+	"os"
+	"strconv"
+)
+
+const (
+	coinbaseRestURL = "https://api.pro.coinbase.com"
+	coinbaseFeedURL = "wss://ws-feed.pro.coinbase.com"
+	sandboxRestURL  = "https://api-public.sandbox.pro.coinbase.com"
+	sandboxFeedURL  = "wss://ws-feed-public.sandbox.pro.coinbase.com"
+)
+
+// Endpoint is a pair of REST and websocket feed base URLs.
+type Endpoint struct {
+	Rest string `json:"rest"`
+	Feed string `json:"feed"`
+}
+
+// environment is the Endpoint used by any Api that does not define its own.
+var environment = Endpoint{coinbaseRestURL, coinbaseFeedURL}
+
+// dotenv holds the variables of the .env file, if there is one.
+var dotenv, _ = godotenv.Read(".env")
+
+// env reads a variable from the OS environment, falling back to the .env file.
+func env(key string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return dotenv[key]
+}
+
+func init() {
+
+	if sandbox, err := strconv.ParseBool(env("COINBASE_SANDBOX")); err == nil && sandbox {
+		environment = Endpoint{sandboxRestURL, sandboxFeedURL}
+	}
+
+	if rest := env("COINBASE_REST_URL"); rest != "" {
+		environment.Rest = rest
+	}
+
+	if feed := env("COINBASE_FEED_URL"); feed != "" {
+		environment.Feed = feed
+	}
+}
+
+// Endpoint resolves the base URLs of the Api, preferring its own URLs, then the sandbox, then the environment.
+func (v *Api) Endpoint() Endpoint {
+
+	endpoint := environment
+	if v.Sandbox {
+		endpoint = Endpoint{sandboxRestURL, sandboxFeedURL}
+	}
+
+	if v.Rest != "" {
+		endpoint.Rest = v.Rest
+	}
+
+	if v.Feed != "" {
+		endpoint.Feed = v.Feed
+	}
+
+	return endpoint
+}
@@ -0,0 +1,16 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestEndpoint(t *testing.T) {
+
+	if e := (&Api{Sandbox: true}).Endpoint(); e.Rest != sandboxRestURL || e.Feed != sandboxFeedURL {
+		t.Fail()
+	}
+
+	if e := (&Api{Rest: "http://localhost:9090"}).Endpoint(); e.Rest != "http://localhost:9090" || e.Feed != environment.Feed {
+		t.Fail()
+	}
+}
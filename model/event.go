@@ -0,0 +1,128 @@
+package model
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	patternEvent  EventType = "pattern"
+	campEvent               = "camp"
+	boundEvent              = "bound"
+	disabledEvent           = "disabled"
+	anchorEvent             = "anchor"
+	reanchorEvent           = "reanchor"
+	goalEvent               = "goal"
+	gainEvent               = "gain"
+	lossEvent               = "loss"
+	exitEvent               = "exit"
+	errorEvent              = "error"
+)
+
+const (
+	buySession  = "buy"
+	sellSession = "sell"
+)
+
+// Event is something that happened to a session, published to the listeners of its user.
+type Event struct {
+	Type        EventType `json:"type"`
+	UserID      uint      `json:"user_id"`
+	SessionID   uint      `json:"session_id"`
+	SessionType string    `json:"session_type"`
+	ProductID   string    `json:"product_id"`
+	Price       float64   `json:"price,omitempty"`
+	OrderID     string    `json:"order_id,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// Listener receives the events of a user's sessions until it is closed.
+type Listener struct {
+	Events chan Event
+	userID uint
+}
+
+// bus maps each user to the listeners of their session events.
+var bus = struct {
+	sync.Mutex
+	m map[uint]map[*Listener]bool
+}{m: map[uint]map[*Listener]bool{}}
+
+// Listen returns a Listener of the events of a user's sessions.
+func Listen(userID uint) *Listener {
+	bus.Lock()
+	defer bus.Unlock()
+	l := &Listener{Events: make(chan Event, 32), userID: userID}
+	if bus.m[userID] == nil {
+		bus.m[userID] = map[*Listener]bool{}
+	}
+	bus.m[userID][l] = true
+	return l
+}
+
+func (l *Listener) Close() {
+	bus.Lock()
+	defer bus.Unlock()
+	delete(bus.m[l.userID], l)
+	if len(bus.m[l.userID]) == 0 {
+		delete(bus.m, l.userID)
+	}
+}
+
+// publish offers an event to every listener of its user without blocking the session,
+// dropping the oldest event of a listener that falls behind.
+func publish(e Event) {
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	bus.Lock()
+	defer bus.Unlock()
+
+	for l := range bus.m[e.UserID] {
+		for sent := false; !sent; {
+			select {
+			case l.Events <- e:
+				sent = true
+			default:
+				select {
+				case <-l.Events:
+				default:
+				}
+			}
+		}
+	}
+}
+
+func (s *Session) event(eventType EventType, sessionType string) Event {
+	return Event{
+		Type:        eventType,
+		UserID:      s.UserID,
+		SessionID:   s.ID,
+		SessionType: sessionType,
+		ProductID:   s.ProductID,
+	}
+}
+
+func (s *BuySession) publish(eventType EventType, price float64, err error) {
+	e := s.event(eventType, buySession)
+	e.Price = price
+	if err != nil {
+		e.Error = err.Error()
+	}
+	publish(e)
+}
+
+func (s *SellSession) publish(eventType EventType, price float64, err error) {
+	e := s.event(eventType, sellSession)
+	e.Price = price
+	e.OrderID = s.OrderID
+	if err != nil {
+		e.Error = err.Error()
+	}
+	publish(e)
+}
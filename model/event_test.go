@@ -0,0 +1,39 @@
+package model
+
+import (
+	"errors"
+	"nuchal-api/util"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+
+	listener := Listen(userID)
+	other := Listen(userID + 1)
+
+	s := &SellSession{Session: Session{UserID: userID, ProductID: productID}, OrderID: "abc"}
+	s.publish(anchorEvent, 1, nil)
+	s.publish(errorEvent, 0, errors.New("boom"))
+
+	anchor := <-listener.Events
+	if anchor.Type != anchorEvent || anchor.OrderID != "abc" || anchor.SessionType != sellSession {
+		t.Fail()
+	}
+
+	if e := <-listener.Events; e.Type != errorEvent || e.Error != "boom" {
+		t.Fail()
+	}
+
+	if len(other.Events) != 0 {
+		t.Fail()
+	}
+
+	listener.Close()
+	other.Close()
+
+	for i := 0; i < 64; i++ {
+		s.publish(goalEvent, 2, nil)
+	}
+
+	util.PrettyPrint(anchor)
+}
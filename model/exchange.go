@@ -0,0 +1,123 @@
+package model
+
+import (
+	"errors"
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Exchange is the set of venue operations the model layer depends on.
+type Exchange interface {
+	Market
+	CreateOrder(order *cb.Order) (cb.Order, error)
+	GetOrder(orderID string) (cb.Order, error)
+	CancelOrder(orderID string) error
+	CancelAllOrders(productID string) ([]string, error)
+	ListOrders(productID string) ([]cb.Order, error)
+	ListFills(productID string) ([]cb.Fill, error)
+	GetAccounts() ([]cb.Account, error)
+}
+
+const (
+	coinbaseVenue = "coinbase"
+	fakeVenue     = "fake"
+)
+
+// exchanges maps a venue name to the constructor of its Exchange.
+var exchanges = struct {
+	sync.RWMutex
+	m map[string]func(*Api) Exchange
+}{m: map[string]func(*Api) Exchange{
+	coinbaseVenue: newCoinbase,
+}}
+
+// RegisterExchange makes an Exchange available to every Api with a Venue equal to name.
+func RegisterExchange(name string, fn func(*Api) Exchange) {
+	exchanges.Lock()
+	defer exchanges.Unlock()
+	exchanges.m[name] = fn
+}
+
+// exchangeFor returns the constructor of a venue's Exchange, Coinbase Pro for no venue, false when the venue is unknown.
+func exchangeFor(venue string) (func(*Api) Exchange, bool) {
+	if venue == "" {
+		return newCoinbase, true
+	}
+	exchanges.RLock()
+	defer exchanges.RUnlock()
+	fn, ok := exchanges.m[venue]
+	return fn, ok
+}
+
+// unknownExchange is the Exchange of a venue nothing registered, failing every call rather than trading elsewhere.
+type unknownExchange struct {
+	err error
+}
+
+func newUnknownExchange(venue string) Exchange {
+	return unknownExchange{errors.New("unknown venue " + venue)}
+}
+
+func (u unknownExchange) GetCurrencies() ([]cb.Currency, error) { return nil, u.err }
+func (u unknownExchange) GetProducts() ([]cb.Product, error)    { return nil, u.err }
+func (u unknownExchange) GetHistoricRates(string, cb.GetHistoricRatesParams) ([]cb.HistoricRate, error) {
+	return nil, u.err
+}
+func (u unknownExchange) CreateOrder(*cb.Order) (cb.Order, error)  { return cb.Order{}, u.err }
+func (u unknownExchange) GetOrder(string) (cb.Order, error)        { return cb.Order{}, u.err }
+func (u unknownExchange) CancelOrder(string) error                 { return u.err }
+func (u unknownExchange) CancelAllOrders(string) ([]string, error) { return nil, u.err }
+func (u unknownExchange) ListOrders(string) ([]cb.Order, error)    { return nil, u.err }
+func (u unknownExchange) ListFills(string) ([]cb.Fill, error)      { return nil, u.err }
+func (u unknownExchange) GetAccounts() ([]cb.Account, error)       { return nil, u.err }
+
+// coinbase is the Coinbase Pro implementation of Exchange.
+type coinbase struct {
+	*cb.Client
+}
+
+func newCoinbase(v *Api) Exchange {
+	return &coinbase{&cb.Client{
+		BaseURL:    v.Endpoint().Rest,
+		Secret:     v.Secret,
+		Key:        v.Key,
+		Passphrase: v.Pass,
+		HTTPClient: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}}
+}
+
+func (c *coinbase) CancelAllOrders(productID string) ([]string, error) {
+	return c.Client.CancelAllOrders(cb.CancelAllOrdersParams{ProductID: productID})
+}
+
+func (c *coinbase) ListOrders(productID string) ([]cb.Order, error) {
+	var orders, allOrders []cb.Order
+	cursor := c.Client.ListOrders(cb.ListOrdersParams{ProductID: productID})
+	for cursor.HasMore {
+		if err := cursor.NextPage(&orders); err != nil {
+			return nil, err
+		}
+		allOrders = append(allOrders, orders...)
+	}
+	return allOrders, nil
+}
+
+func (c *coinbase) ListFills(productID string) ([]cb.Fill, error) {
+	var fills, allFills []cb.Fill
+	cursor := c.Client.ListFills(cb.ListFillsParams{ProductID: productID})
+	for cursor.HasMore {
+		if err := cursor.NextPage(&fills); err != nil {
+			return nil, err
+		}
+		allFills = append(allFills, fills...)
+	}
+	return allFills, nil
+}
+
+func (c *coinbase) GetHistoricRates(productID string, params cb.GetHistoricRatesParams) ([]cb.HistoricRate, error) {
+	return c.Client.GetHistoricRates(productID, params)
+}
@@ -0,0 +1,433 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeExchange is an in-memory Exchange for exercising sessions, liquidation and history without keys or network.
+// Market orders fill at the last price set for a product, limit and stop orders rest until SetPrice crosses them.
+type FakeExchange struct {
+	mu         sync.Mutex
+	maker      float64
+	taker      float64
+	sequence   int
+	accounts   map[string]*cb.Account
+	orders     map[string]*cb.Order
+	fills      []cb.Fill
+	prices     map[string]float64
+	products   []cb.Product
+	currencies []cb.Currency
+	candles    map[string][]cb.HistoricRate
+}
+
+var fakes = struct {
+	sync.Mutex
+	m map[string]*FakeExchange
+}{m: map[string]*FakeExchange{}}
+
+func init() {
+	RegisterExchange(fakeVenue, func(v *Api) Exchange {
+		fakes.Lock()
+		defer fakes.Unlock()
+		if _, ok := fakes.m[v.Key]; !ok {
+			fakes.m[v.Key] = NewFakeExchange(v.Maker, v.Taker)
+		}
+		return fakes.m[v.Key]
+	})
+}
+
+func NewFakeExchange(maker, taker float64) *FakeExchange {
+	return &FakeExchange{
+		maker:    maker,
+		taker:    taker,
+		accounts: map[string]*cb.Account{},
+		orders:   map[string]*cb.Order{},
+		prices:   map[string]float64{},
+		candles:  map[string][]cb.HistoricRate{},
+	}
+}
+
+// SetFees changes the maker and taker fees charged on later fills.
+func (f *FakeExchange) SetFees(maker, taker float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.maker, f.taker = maker, taker
+}
+
+// Deposit credits the given currency balance.
+func (f *FakeExchange) Deposit(currency string, amount float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.credit(currency, amount)
+}
+
+// AddProduct makes a product and its currencies available to GetProducts and GetCurrencies.
+func (f *FakeExchange) AddProduct(product cb.Product, currencies ...cb.Currency) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.products = append(f.products, product)
+	f.currencies = append(f.currencies, currencies...)
+}
+
+// AddCandles stores candles served by GetHistoricRates, the last close becomes the product price.
+func (f *FakeExchange) AddCandles(productID string, rates ...cb.HistoricRate) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.candles[productID] = append(f.candles[productID], rates...)
+	sort.SliceStable(f.candles[productID], func(i, j int) bool {
+		return f.candles[productID][i].Time.Before(f.candles[productID][j].Time)
+	})
+	if last := f.candles[productID][len(f.candles[productID])-1]; last.Close > 0 {
+		f.prices[productID] = last.Close
+	}
+}
+
+// SetPrice moves the market price of a product, triggering and filling any resting orders it crosses.
+func (f *FakeExchange) SetPrice(productID string, price float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setPrice(productID, price)
+}
+
+// Price returns the last price set for a product, zero when unknown.
+func (f *FakeExchange) Price(productID string) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.prices[productID]
+}
+
+func (f *FakeExchange) setPrice(productID string, price float64) int {
+	var filled int
+	f.prices[productID] = price
+	for _, order := range f.openOrders(productID) {
+		if f.crosses(order, price) {
+			f.fill(order, fakeFloat(order.Price), f.maker)
+			filled++
+		}
+	}
+	return filled
+}
+
+func (f *FakeExchange) CreateOrder(order *cb.Order) (cb.Order, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	base, quote := fakeCurrencies(order.ProductID)
+	if base == "" || quote == "" {
+		return cb.Order{}, errors.New("product not found")
+	}
+
+	f.sequence++
+
+	o := *order
+	o.ID = fmt.Sprintf("fake-%d", f.sequence)
+	o.CreatedAt = cb.Time(time.Now().UTC())
+	if o.Type == "" {
+		o.Type = "limit"
+	}
+
+	if o.Type == "market" {
+		price := f.prices[o.ProductID]
+		if price == 0 {
+			return cb.Order{}, errors.New("no price for " + o.ProductID)
+		}
+		if o.Size == "" {
+			o.Size = fakeText(fakeFloat(o.Funds) / price / (1 + f.taker))
+		}
+		if err := f.available(o, price, f.taker); err != nil {
+			return cb.Order{}, err
+		}
+		f.orders[o.ID] = &o
+		f.fill(&o, price, f.taker)
+		return o, nil
+	}
+
+	if err := f.available(o, fakeFloat(o.Price), f.maker); err != nil {
+		return cb.Order{}, err
+	}
+
+	o.Status = "open"
+	if o.Stop != "" {
+		o.Status = "pending"
+	}
+	f.orders[o.ID] = &o
+	f.hold(&o, 1)
+
+	return o, nil
+}
+
+func (f *FakeExchange) GetOrder(orderID string) (cb.Order, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if order, ok := f.orders[orderID]; ok {
+		return *order, nil
+	}
+	return cb.Order{}, errors.New("NotFound")
+}
+
+func (f *FakeExchange) CancelOrder(orderID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	order, ok := f.orders[orderID]
+	if !ok || order.Status == "done" {
+		return errors.New("order not found")
+	}
+	f.hold(order, -1)
+	delete(f.orders, orderID)
+	return nil
+}
+
+func (f *FakeExchange) CancelAllOrders(productID string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, order := range f.openOrders(productID) {
+		f.hold(order, -1)
+		delete(f.orders, order.ID)
+		ids = append(ids, order.ID)
+	}
+	return ids, nil
+}
+
+func (f *FakeExchange) ListOrders(productID string) ([]cb.Order, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var orders []cb.Order
+	for _, order := range f.openOrders(productID) {
+		orders = append(orders, *order)
+	}
+	return orders, nil
+}
+
+func (f *FakeExchange) ListFills(productID string) ([]cb.Fill, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var fills []cb.Fill
+	for i := len(f.fills) - 1; i >= 0; i-- {
+		if productID == "" || f.fills[i].ProductID == productID {
+			fills = append(fills, f.fills[i])
+		}
+	}
+	return fills, nil
+}
+
+func (f *FakeExchange) GetAccounts() ([]cb.Account, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var accounts []cb.Account
+	for _, account := range f.accounts {
+		accounts = append(accounts, *account)
+	}
+	sort.SliceStable(accounts, func(i, j int) bool {
+		return accounts[i].Currency < accounts[j].Currency
+	})
+	return accounts, nil
+}
+
+func (f *FakeExchange) GetCurrencies() ([]cb.Currency, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]cb.Currency{}, f.currencies...), nil
+}
+
+func (f *FakeExchange) GetProducts() ([]cb.Product, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]cb.Product{}, f.products...), nil
+}
+
+// GetHistoricRates returns stored candles within the params window, newest first like Coinbase Pro.
+func (f *FakeExchange) GetHistoricRates(productID string, params cb.GetHistoricRatesParams) ([]cb.HistoricRate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var rates []cb.HistoricRate
+	for i := len(f.candles[productID]) - 1; i >= 0; i-- {
+		rate := f.candles[productID][i]
+		if !params.Start.IsZero() && rate.Time.Before(params.Start) {
+			continue
+		}
+		if !params.End.IsZero() && rate.Time.After(params.End) {
+			continue
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+// fakeState is the serializable form of a FakeExchange.
+type fakeState struct {
+	Sequence int                    `json:"sequence"`
+	Accounts map[string]*cb.Account `json:"accounts"`
+	Orders   map[string]*cb.Order   `json:"orders"`
+	Fills    []cb.Fill              `json:"fills"`
+	Prices   map[string]float64     `json:"prices"`
+}
+
+func (f *FakeExchange) state() ([]byte, error) {
+	return json.Marshal(fakeState{f.sequence, f.accounts, f.orders, f.fills, f.prices})
+}
+
+func (f *FakeExchange) restore(data []byte) error {
+	var state fakeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	f.sequence = state.Sequence
+	f.fills = state.Fills
+	if state.Accounts != nil {
+		f.accounts = state.Accounts
+	}
+	if state.Orders != nil {
+		f.orders = state.Orders
+	}
+	if state.Prices != nil {
+		f.prices = state.Prices
+	}
+	return nil
+}
+
+func (f *FakeExchange) account(currency string) *cb.Account {
+	if _, ok := f.accounts[currency]; !ok {
+		f.accounts[currency] = &cb.Account{
+			ID:        "fake-" + strings.ToLower(currency),
+			Currency:  currency,
+			Balance:   "0",
+			Hold:      "0",
+			Available: "0",
+		}
+	}
+	return f.accounts[currency]
+}
+
+func (f *FakeExchange) credit(currency string, amount float64) {
+	a := f.account(currency)
+	a.Balance = fakeText(fakeFloat(a.Balance) + amount)
+	a.Available = fakeText(fakeFloat(a.Balance) - fakeFloat(a.Hold))
+}
+
+// hold reserves (sign 1) or releases (sign -1) the funds a resting order needs.
+func (f *FakeExchange) hold(order *cb.Order, sign float64) {
+	base, quote := fakeCurrencies(order.ProductID)
+	currency, amount := base, fakeFloat(order.Size)
+	if order.Side == "buy" {
+		currency, amount = quote, fakeFloat(order.Size)*fakeFloat(order.Price)*(1+f.maker)
+	}
+	a := f.account(currency)
+	a.Hold = fakeText(fakeFloat(a.Hold) + amount*sign)
+	a.Available = fakeText(fakeFloat(a.Balance) - fakeFloat(a.Hold))
+}
+
+func (f *FakeExchange) available(order cb.Order, price, fee float64) error {
+	base, quote := fakeCurrencies(order.ProductID)
+	size := fakeFloat(order.Size)
+	if order.Side == "buy" {
+		if fakeFloat(f.account(quote).Available) < size*price*(1+fee) {
+			return errors.New("Insufficient funds")
+		}
+		return nil
+	}
+	if fakeFloat(f.account(base).Available) < size {
+		return errors.New("Insufficient funds")
+	}
+	return nil
+}
+
+func (f *FakeExchange) crosses(order *cb.Order, price float64) bool {
+	if order.Stop == "loss" {
+		return price <= fakeFloat(order.StopPrice)
+	}
+	if order.Stop == "entry" {
+		return price >= fakeFloat(order.StopPrice)
+	}
+	if order.Side == "buy" {
+		return price <= fakeFloat(order.Price)
+	}
+	return price >= fakeFloat(order.Price)
+}
+
+func (f *FakeExchange) fill(order *cb.Order, price, rate float64) {
+
+	base, quote := fakeCurrencies(order.ProductID)
+	size := fakeFloat(order.Size)
+	value := size * price
+	fee := value * rate
+
+	if order.Status != "" {
+		f.hold(order, -1)
+	}
+
+	if order.Side == "buy" {
+		f.credit(quote, -(value + fee))
+		f.credit(base, size)
+	} else {
+		f.credit(base, -size)
+		f.credit(quote, value-fee)
+	}
+
+	liquidity := "T"
+	if order.Type == "limit" {
+		liquidity = "M"
+	}
+
+	order.Status = "done"
+	order.DoneReason = "filled"
+	order.Settled = true
+	order.FilledSize = fakeText(size)
+	order.ExecutedValue = fakeText(value)
+	order.FillFees = fakeText(fee)
+
+	f.fills = append(f.fills, cb.Fill{
+		TradeID:   len(f.fills) + 1,
+		ProductID: order.ProductID,
+		Price:     fakeText(price),
+		Size:      fakeText(size),
+		FillID:    order.ID,
+		CreatedAt: cb.Time(time.Now().UTC()),
+		Fee:       fakeText(fee),
+		Settled:   true,
+		Side:      order.Side,
+		Liquidity: liquidity,
+	})
+}
+
+func (f *FakeExchange) openOrders(productID string) []*cb.Order {
+	var orders []*cb.Order
+	for _, order := range f.orders {
+		if order.Status == "done" {
+			continue
+		}
+		if productID == "" || order.ProductID == productID {
+			orders = append(orders, order)
+		}
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.Time().Before(orders[j].CreatedAt.Time())
+	})
+	return orders
+}
+
+func fakeCurrencies(productID string) (string, string) {
+	sides := strings.Split(productID, "-")
+	if len(sides) != 2 {
+		return "", ""
+	}
+	return sides[0], sides[1]
+}
+
+func fakeFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func fakeText(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
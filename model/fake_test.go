@@ -0,0 +1,76 @@
+package model
+
+import (
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"nuchal-api/util"
+	"testing"
+)
+
+func TestFakeExchange(t *testing.T) {
+
+	f := NewFakeExchange(0.005, 0.005)
+	f.Deposit("USD", 100)
+	f.SetPrice("ALGO-USD", 1)
+
+	buy, err := f.CreateOrder(&cb.Order{ProductID: "ALGO-USD", Side: "buy", Size: "10", Type: "market"})
+	if err != nil || buy.Status != "done" {
+		t.Fail()
+	}
+
+	stop, err := f.CreateOrder(&cb.Order{ProductID: "ALGO-USD", Side: "sell", Size: "10", Type: "limit", Price: "0.9", StopPrice: "0.9", Stop: "loss"})
+	if err != nil {
+		t.Fail()
+	}
+
+	f.SetPrice("ALGO-USD", 0.89)
+
+	if order, _ := f.GetOrder(stop.ID); order.Status != "done" {
+		t.Fail()
+	}
+
+	fills, _ := f.ListFills("ALGO-USD")
+	if len(fills) != 2 {
+		t.Fail()
+	}
+
+	accounts, _ := f.GetAccounts()
+	util.PrettyPrint(accounts)
+}
+
+func TestFakeExchangeSetFees(t *testing.T) {
+
+	f := NewFakeExchange(0.005, 0.005)
+	f.Deposit("USD", 100)
+	f.SetPrice("ALGO-USD", 1)
+	f.SetFees(0, 0.01)
+
+	if _, err := f.CreateOrder(&cb.Order{ProductID: "ALGO-USD", Side: "buy", Size: "10", Type: "market"}); err != nil {
+		t.Fail()
+	}
+
+	fills, _ := f.ListFills("ALGO-USD")
+	if len(fills) != 1 || fills[0].Fee != "0.1" {
+		util.PrettyPrint(fills)
+		t.Fail()
+	}
+}
+
+func TestFakeExchangeCanceledNotFound(t *testing.T) {
+
+	f := NewFakeExchange(0.005, 0.005)
+	f.Deposit("ALGO", 10)
+	f.SetPrice("ALGO-USD", 1)
+
+	limit, err := f.CreateOrder(&cb.Order{ProductID: "ALGO-USD", Side: "sell", Size: "10", Type: "limit", Price: "1.1"})
+	if err != nil {
+		t.Fail()
+	}
+
+	if err = f.CancelOrder(limit.ID); err != nil {
+		t.Fail()
+	}
+
+	if _, err = f.GetOrder(limit.ID); err == nil || !notFound(err) {
+		t.Fail()
+	}
+}
@@ -37,19 +37,10 @@ func GetAllFills(userID uint, productID string) ([]cb.Fill, error) {
 
 	u := FindUserByID(userID)
 
-	cursor := u.Client().ListFills(cb.ListFillsParams{ProductID: productID})
-
-	var newFills, allFills []cb.Fill
-	for cursor.HasMore {
-
-		if err := cursor.NextPage(&newFills); err != nil {
-			log.Err(err).Stack().Send()
-			return nil, err
-		}
-
-		for _, chunk := range newFills {
-			allFills = append(allFills, chunk)
-		}
+	allFills, err := u.Client().ListFills(productID)
+	if err != nil {
+		log.Err(err).Stack().Send()
+		return nil, err
 	}
 
 	sort.SliceStable(allFills, func(i, j int) bool {
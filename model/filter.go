@@ -0,0 +1,371 @@
+package model
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"math"
+	"nuchal-api/db"
+	"nuchal-api/indicator"
+	"strings"
+	"time"
+)
+
+// Indicator names what an Operand reads from the rates.
+type Indicator string
+
+const (
+	valueIndicator     Indicator = ""
+	openIndicator                = "open"
+	highIndicator                = "high"
+	lowIndicator                 = "low"
+	closeIndicator               = "close"
+	volumeIndicator              = "volume"
+	smaIndicator                 = "sma"
+	emaIndicator                 = "ema"
+	rsiIndicator                 = "rsi"
+	macdIndicator                = "macd"
+	signalIndicator              = "macd_signal"
+	histogramIndicator           = "macd_histogram"
+	upperIndicator               = "bb_upper"
+	middleIndicator              = "bb_middle"
+	lowerIndicator               = "bb_lower"
+	atrIndicator                 = "atr"
+	vwapIndicator                = "vwap"
+	obvIndicator                 = "obv"
+)
+
+// Comparison is how a Filter compares its operands.
+type Comparison string
+
+const (
+	below        Comparison = "<"
+	belowOrEqual            = "<="
+	above                   = ">"
+	aboveOrEqual            = ">="
+)
+
+// Filter is a condition on indicators that must hold for a pattern to enter, in addition to its formation.
+// e.g. rsi(14) < 30, close > ema(200), volume > sma(volume,20), close > vwap(60)
+type Filter struct {
+	UintModel
+	PatternID uint       `json:"pattern_id"`
+	Left      Operand    `json:"left" gorm:"embedded;embeddedPrefix:left_"`
+	Op        Comparison `json:"op"`
+	Right     Operand    `json:"right" gorm:"embedded;embeddedPrefix:right_"`
+}
+
+// Operand is a constant Value, a field of the latest rate, or the latest value of an indicator.
+type Operand struct {
+	Indicator Indicator `json:"indicator"`
+
+	// Source is the field averaged by sma, ema, rsi, macd and the bands, the close by default.
+	Source Indicator `json:"source"`
+
+	// Period is the length of the indicator, or the fast period of macd. vwap and obv total the last Period rates.
+	Period int `json:"period"`
+
+	// Slow and Signal are the other periods of macd, 26 and 9 by default.
+	Slow   int `json:"slow"`
+	Signal int `json:"signal"`
+
+	// Deviations is the width of the bands, 2 by default.
+	Deviations float64 `json:"deviations"`
+
+	// Value is the constant of an operand without an indicator.
+	Value float64 `json:"value"`
+}
+
+func init() {
+	db.Migrate(&Filter{})
+}
+
+func (f Filter) String() string {
+	return fmt.Sprintf("%s %s %s", f.Left, f.Op, f.Right)
+}
+
+func (o Operand) String() string {
+	switch o.Indicator {
+	case valueIndicator:
+		return fmt.Sprintf("%g", o.Value)
+	case openIndicator, highIndicator, lowIndicator, closeIndicator, volumeIndicator:
+		return string(o.Indicator)
+	}
+	var args []string
+	if o.Source != "" && o.Source != closeIndicator {
+		args = append(args, string(o.Source))
+	}
+	args = append(args, fmt.Sprint(o.Period))
+	if o.isMACD() {
+		args = append(args, fmt.Sprint(o.slow()), fmt.Sprint(o.signal()))
+	}
+	if o.isBand() {
+		args = append(args, fmt.Sprintf("%g", o.deviations()))
+	}
+	return fmt.Sprintf("%s(%s)", o.Indicator, strings.Join(args, ","))
+}
+
+func (f *Filter) validate() error {
+	switch f.Op {
+	case below, belowOrEqual, above, aboveOrEqual:
+	default:
+		return fmt.Errorf("filter %s: unknown comparison %q", f, f.Op)
+	}
+	for _, o := range []Operand{f.Left, f.Right} {
+		if err := o.validate(); err != nil {
+			return fmt.Errorf("filter %s: %s", f, err)
+		}
+	}
+	return nil
+}
+
+func (o Operand) validate() error {
+	switch o.Indicator {
+	case valueIndicator, openIndicator, highIndicator, lowIndicator, closeIndicator, volumeIndicator:
+		return nil
+	case smaIndicator, emaIndicator, rsiIndicator, atrIndicator, macdIndicator, signalIndicator, histogramIndicator,
+		upperIndicator, middleIndicator, lowerIndicator, vwapIndicator, obvIndicator:
+	default:
+		return fmt.Errorf("unknown indicator %q", o.Indicator)
+	}
+	switch o.Source {
+	case "", openIndicator, highIndicator, lowIndicator, closeIndicator, volumeIndicator:
+	default:
+		return fmt.Errorf("unknown source %q", o.Source)
+	}
+	if o.Period < 1 {
+		return fmt.Errorf("%s needs a period", o.Indicator)
+	}
+	if o.isMACD() && o.slow() <= o.Period {
+		return fmt.Errorf("%s needs a slow period longer than %d", o.Indicator, o.Period)
+	}
+	if o.Slow < 0 || o.Signal < 0 || o.Deviations < 0 {
+		return fmt.Errorf("%s has a negative parameter", o.Indicator)
+	}
+	return nil
+}
+
+func (o Operand) isMACD() bool {
+	return o.Indicator == macdIndicator || o.Indicator == signalIndicator || o.Indicator == histogramIndicator
+}
+
+func (o Operand) isBand() bool {
+	return o.Indicator == upperIndicator || o.Indicator == middleIndicator || o.Indicator == lowerIndicator
+}
+
+// readsCandles reports whether the indicator reads whole rates rather than a source field of them.
+func (o Operand) readsCandles() bool {
+	return o.Indicator == atrIndicator || o.Indicator == vwapIndicator || o.Indicator == obvIndicator
+}
+
+func (o Operand) slow() int {
+	if o.Slow > 0 {
+		return o.Slow
+	}
+	return 26
+}
+
+func (o Operand) signal() int {
+	if o.Signal > 0 {
+		return o.Signal
+	}
+	return 9
+}
+
+func (o Operand) deviations() float64 {
+	if o.Deviations > 0 {
+		return o.Deviations
+	}
+	return 2
+}
+
+// lookback is how many rates the operand reads, giving smoothed indicators several periods to settle.
+func (o Operand) lookback() int {
+	switch o.Indicator {
+	case smaIndicator, upperIndicator, middleIndicator, lowerIndicator:
+		return o.Period
+	case emaIndicator, rsiIndicator, atrIndicator:
+		return o.Period * 3
+	case macdIndicator, signalIndicator, histogramIndicator:
+		return (o.slow() + o.signal()) * 3
+	case vwapIndicator:
+		return o.Period
+	case obvIndicator:
+		// the first rate only sets the close the next is compared with
+		return o.Period + 1
+	}
+	return 1
+}
+
+// source is the field of each rate the indicator reads.
+func (o Operand) source(rates []Rate) []float64 {
+	values := make([]float64, len(rates))
+	for i, r := range rates {
+		values[i] = r.field(o.Source)
+	}
+	return values
+}
+
+func (r *Rate) field(field Indicator) float64 {
+	switch field {
+	case openIndicator:
+		return r.Open
+	case highIndicator:
+		return r.High
+	case lowIndicator:
+		return r.Low
+	case volumeIndicator:
+		return r.Volume
+	}
+	return r.Close
+}
+
+// value is the operand as of the latest rate, false until the indicator has enough rates.
+func (o Operand) value(rates []Rate) (float64, bool) {
+
+	if o.Indicator == valueIndicator {
+		return o.Value, true
+	}
+
+	if len(rates) == 0 {
+		return 0, false
+	}
+
+	var series []float64
+	switch o.Indicator {
+	case openIndicator, highIndicator, lowIndicator, closeIndicator, volumeIndicator:
+		latest := rates[len(rates)-1]
+		return latest.field(o.Indicator), true
+	case smaIndicator:
+		series = indicator.SMA(o.source(rates), o.Period)
+	case emaIndicator:
+		series = indicator.EMA(o.source(rates), o.Period)
+	case rsiIndicator:
+		series = indicator.RSI(o.source(rates), o.Period)
+	case macdIndicator, signalIndicator, histogramIndicator:
+		macd, signal, histogram := indicator.MACD(o.source(rates), o.Period, o.slow(), o.signal())
+		series = map[Indicator][]float64{macdIndicator: macd, signalIndicator: signal, histogramIndicator: histogram}[o.Indicator]
+	case upperIndicator, middleIndicator, lowerIndicator:
+		middle, upper, lower := indicator.Bollinger(o.source(rates), o.Period, o.deviations())
+		series = map[Indicator][]float64{upperIndicator: upper, middleIndicator: middle, lowerIndicator: lower}[o.Indicator]
+	case atrIndicator:
+		series = indicator.ATR(Candles(rates), o.Period)
+	case vwapIndicator, obvIndicator:
+		if o.Period < 1 || len(rates) < o.lookback() {
+			return 0, false
+		}
+		candles := Candles(recent(rates, o.lookback()))
+		if o.Indicator == vwapIndicator {
+			series = indicator.VWAP(candles)
+		} else {
+			series = indicator.OBV(candles)
+		}
+	default:
+		return 0, false
+	}
+
+	v := series[len(series)-1]
+	return v, !math.IsNaN(v)
+}
+
+// holds reports whether the filter holds as of the latest rate, which it does not until both operands are ready.
+func (f *Filter) holds(rates []Rate) bool {
+
+	left, ok := f.Left.value(rates)
+	if !ok {
+		return false
+	}
+
+	right, ok := f.Right.value(rates)
+	if !ok {
+		return false
+	}
+
+	switch f.Op {
+	case below:
+		return left < right
+	case belowOrEqual:
+		return left <= right
+	case above:
+		return left > right
+	case aboveOrEqual:
+		return left >= right
+	}
+	return false
+}
+
+// lookback is how many of the most recent rates the pattern reads to match its formation and filters.
+func (p *Pattern) lookback() int {
+	n := p.window()
+	for _, rule := range []*Rule{p.entryRule(), p.exitRule()} {
+		if rule != nil && rule.lookback() > n {
+			n = rule.lookback()
+		}
+	}
+	for _, f := range p.Filters {
+		for _, o := range []Operand{f.Left, f.Right} {
+			if o.lookback() > n {
+				n = o.lookback()
+			}
+		}
+	}
+	return n
+}
+
+// history is the minute rates before the current minute that the pattern's filters and rules read, so a session
+// need not wait for its indicators to warm up.
+func (p *Pattern) history() []Rate {
+	if n := p.lookback(); n > p.window() {
+		return history(p.ProductID, n)
+	}
+	return nil
+}
+
+// history is the last n minute rates of a product before the current minute.
+func history(productID string, n int) []Rate {
+
+	omega := time.Now().Truncate(time.Minute)
+	alpha := omega.Add(-time.Duration(n) * time.Minute)
+
+	rates, err := GetRates(productID, alpha.Unix(), omega.Unix(), Minute)
+	if err != nil {
+		log.Err(err).Str("productID", productID).Send()
+		return nil
+	}
+
+	var history []Rate
+	for _, rate := range rates {
+		if rate.UnixSecond < omega.Unix() {
+			history = append(history, rate)
+		}
+	}
+	return history
+}
+
+// veto returns the first filter, or the entry rule, that does not hold as of the latest rate.
+func (p *Pattern) veto(rates []Rate) (string, bool) {
+	for _, f := range p.Filters {
+		if !f.holds(rates) {
+			return f.String(), true
+		}
+	}
+	if rule := p.entryRule(); rule != nil && !rule.Holds(rates) {
+		return rule.String(), true
+	}
+	return "", false
+}
+
+// saveFilters replaces the filters of a saved pattern.
+func (p *Pattern) saveFilters(tx *gorm.DB) error {
+	if err := tx.Unscoped().Where("pattern_id = ?", p.ID).Delete(&Filter{}).Error; err != nil {
+		return err
+	}
+	for i := range p.Filters {
+		p.Filters[i].ID = 0
+		p.Filters[i].PatternID = p.ID
+	}
+	if len(p.Filters) > 0 {
+		return tx.Create(&p.Filters).Error
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"math"
+	"nuchal-api/util"
+	"testing"
+)
+
+func TestFilters(t *testing.T) {
+
+	var rates []Rate
+	for i := 0; i < 30; i++ {
+		price := float64(100 - i)
+		rates = append(rates, Rate{Open: price + 1, High: price + 1.5, Low: price - 0.5, Close: price, Volume: 10})
+	}
+	rates[29].Volume = 50
+
+	oversold := Filter{Left: Operand{Indicator: rsiIndicator, Period: 14}, Op: below, Right: Operand{Value: 30}}
+	trend := Filter{Left: Operand{Indicator: closeIndicator}, Op: above, Right: Operand{Indicator: emaIndicator, Period: 10}}
+	surge := Filter{Left: Operand{Indicator: volumeIndicator}, Op: above, Right: Operand{Indicator: smaIndicator, Source: volumeIndicator, Period: 20}}
+
+	if !oversold.holds(rates) || trend.holds(rates) || !surge.holds(rates) || surge.holds(rates[:29]) {
+		t.Fail()
+	}
+
+	// not enough rates for the indicator
+	if oversold.holds(rates[:10]) {
+		t.Fail()
+	}
+
+	p := &Pattern{Size: 1, Target: 0.05, Filters: []Filter{oversold, surge}}
+	if _, vetoed := p.veto(rates); vetoed {
+		t.Fail()
+	}
+
+	p.Filters = append(p.Filters, trend)
+	if reason, vetoed := p.veto(rates); !vetoed || reason != "close > ema(10)" {
+		util.PrettyPrint(reason)
+		t.Fail()
+	}
+
+	if p.lookback() != 42 || p.validate(testProduct(), testUser()) != nil {
+		t.Fail()
+	}
+
+	p.Filters = []Filter{{Left: Operand{Indicator: "stochastic", Period: 14}, Op: below, Right: Operand{Value: 20}}}
+	if p.validate(testProduct(), testUser()) == nil {
+		t.Fail()
+	}
+
+	p.Filters = []Filter{{Left: Operand{Indicator: macdIndicator, Period: 30}, Op: "!=", Right: Operand{}}}
+	if err := p.validate(testProduct(), testUser()); err == nil {
+		t.Fail()
+	} else {
+		util.PrettyPrint(err.Error())
+	}
+}
+
+func TestVolumeFilters(t *testing.T) {
+
+	var rates []Rate
+	for i, price := range []float64{10, 11, 12, 11, 13} {
+		rates = append(rates, Rate{Open: price, High: price, Low: price, Close: price, Volume: float64(i + 1)})
+	}
+
+	// vwap(3) is (12*3 + 11*4 + 13*5) / 12, above a single candle's 13 only if it read one candle
+	vwap := Operand{Indicator: vwapIndicator, Period: 3}
+	if v, ok := vwap.value(rates); !ok || math.Abs(v-145.0/12) > 1e-9 {
+		util.PrettyPrint(v)
+		t.Fail()
+	}
+
+	// obv(3) adds 3, takes 4 and adds 5 over the last three closes
+	obv := Operand{Indicator: obvIndicator, Period: 3}
+	if v, ok := obv.value(rates); !ok || v != 4 {
+		util.PrettyPrint(v)
+		t.Fail()
+	}
+
+	rising := Filter{Left: Operand{Indicator: closeIndicator}, Op: above, Right: vwap}
+	accumulating := Filter{Left: obv, Op: above, Right: Operand{Value: 3}}
+	if !rising.holds(rates) || !accumulating.holds(rates) || accumulating.holds(rates[:3]) {
+		t.Fail()
+	}
+
+	if _, ok := (Operand{Indicator: obvIndicator, Period: 5}).value(rates); ok {
+		t.Fail()
+	}
+
+	if rising.String() != "close > vwap(3)" || (Operand{Indicator: vwapIndicator}).validate() == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,142 @@
+package model
+
+import (
+	"math"
+	"sort"
+)
+
+// Formation names a candlestick formation a Pattern enters on.
+type Formation string
+
+const (
+	TweezerBottom      Formation = "tweezer_bottom"
+	BullishEngulfing             = "bullish_engulfing"
+	Hammer                       = "hammer"
+	PiercingLine                 = "piercing_line"
+	MorningStar                  = "morning_star"
+	ThreeWhiteSoldiers           = "three_white_soldiers"
+)
+
+// Detector reports whether a window of the most recent rates, oldest first, completes a formation.
+type Detector struct {
+
+	// Window is the number of rates the detector looks at.
+	Window int
+
+	// Match is given exactly Window rates, and the pattern for its tolerances.
+	Match func(p *Pattern, rates []Rate) bool
+}
+
+// detectors maps a formation to its Detector.
+var detectors = map[Formation]Detector{
+	TweezerBottom:      {3, tweezerBottom},
+	BullishEngulfing:   {2, bullishEngulfing},
+	Hammer:             {2, hammer},
+	PiercingLine:       {2, piercingLine},
+	MorningStar:        {3, morningStar},
+	ThreeWhiteSoldiers: {3, threeWhiteSoldiers},
+}
+
+// RegisterDetector makes a formation available to every Pattern.
+func RegisterDetector(formation Formation, detector Detector) {
+	detectors[formation] = detector
+}
+
+// Formations lists the registered formations by name.
+func Formations() []Formation {
+	var formations []Formation
+	for formation := range detectors {
+		formations = append(formations, formation)
+	}
+	sort.Slice(formations, func(i, j int) bool {
+		return formations[i] < formations[j]
+	})
+	return formations
+}
+
+// detector returns the Detector of the pattern's formation, a tweezer bottom when none is set.
+func (p *Pattern) detector() (Detector, bool) {
+	if p.Formation == "" {
+		return detectors[TweezerBottom], true
+	}
+	d, ok := detectors[p.Formation]
+	return d, ok
+}
+
+// window is the number of rates the pattern's formation looks at.
+func (p *Pattern) window() int {
+	if d, ok := p.detector(); ok {
+		return d.Window
+	}
+	return 0
+}
+
+// Matches reports whether the most recent rates complete the pattern's formation.
+func (p *Pattern) Matches(rates []Rate) bool {
+	d, ok := p.detector()
+	if !ok || len(rates) < d.Window {
+		return false
+	}
+	return d.Match(p, rates[len(rates)-d.Window:])
+}
+
+func (r *Rate) body() float64 {
+	return math.Abs(r.Close - r.Open)
+}
+
+func (r *Rate) mid() float64 {
+	return (r.Open + r.Close) / 2
+}
+
+// tweezerBottom is two down rates then an up rate whose low meets the low of the second within the pattern's delta.
+func tweezerBottom(p *Pattern, rates []Rate) bool {
+	return p.MatchesTweezerBottomPattern(rates[0], rates[1], rates[2])
+}
+
+// bullishEngulfing is a down rate whose body is engulfed by the body of the next, up, rate.
+func bullishEngulfing(p *Pattern, rates []Rate) bool {
+	prev, this := rates[0], rates[1]
+	return prev.IsDown() && this.IsUp() && this.Open <= prev.Close && this.Close >= prev.Open && this.body() > prev.body()
+}
+
+// hammer is a down rate followed by a rate with a small body at the top of a lower shadow at least twice its size.
+func hammer(p *Pattern, rates []Rate) bool {
+	prev, this := rates[0], rates[1]
+	top := math.Max(this.Open, this.Close)
+	bottom := math.Min(this.Open, this.Close)
+	body := this.body()
+	return prev.IsDown() && body > 0 && bottom-this.Low >= 2*body && this.High-top <= body
+}
+
+// piercingLine is a down rate followed by an up rate opening below its close and closing above its midpoint.
+func piercingLine(p *Pattern, rates []Rate) bool {
+	prev, this := rates[0], rates[1]
+	return prev.IsDown() && this.IsUp() && this.Open < prev.Close && this.Close > prev.mid() && this.Close < prev.Open
+}
+
+// morningStar is a long down rate, a small bodied rate below its close, then an up rate closing above its midpoint.
+func morningStar(p *Pattern, rates []Rate) bool {
+	first, star, last := rates[0], rates[1], rates[2]
+	return first.IsDown() &&
+		first.body() > 0 &&
+		star.body() <= first.body()/3 &&
+		math.Max(star.Open, star.Close) <= first.Close &&
+		last.IsUp() &&
+		last.Close > first.mid()
+}
+
+// threeWhiteSoldiers is three up rates, each opening within the body of the last and closing above it.
+func threeWhiteSoldiers(p *Pattern, rates []Rate) bool {
+	for i, rate := range rates {
+		if rate.body() == 0 || !rate.IsUp() {
+			return false
+		}
+		if i > 0 {
+			prev := rates[i-1]
+			if rate.Open < prev.Open || rate.Open > prev.Close || rate.Close <= prev.Close {
+				return false
+			}
+		}
+	}
+	return true
+}
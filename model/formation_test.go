@@ -0,0 +1,39 @@
+package model
+
+import (
+	"nuchal-api/util"
+	"testing"
+)
+
+func TestFormations(t *testing.T) {
+
+	down := Rate{Open: 10, High: 10.5, Low: 8.5, Close: 9}
+
+	cases := []struct {
+		formation Formation
+		rates     []Rate
+	}{
+		{TweezerBottom, []Rate{down, {Open: 9, High: 9.2, Low: 8, Close: 8.5}, {Open: 8.5, High: 9.5, Low: 8, Close: 9.2}}},
+		{BullishEngulfing, []Rate{down, {Open: 8.9, High: 10.5, Low: 8.8, Close: 10.2}}},
+		{Hammer, []Rate{down, {Open: 8.9, High: 9.05, Low: 8.4, Close: 9}}},
+		{PiercingLine, []Rate{down, {Open: 8.8, High: 9.9, Low: 8.7, Close: 9.7}}},
+		{MorningStar, []Rate{down, {Open: 8.8, High: 8.9, Low: 8.5, Close: 8.7}, {Open: 8.8, High: 9.8, Low: 8.8, Close: 9.7}}},
+		{ThreeWhiteSoldiers, []Rate{{Open: 8, Close: 8.5}, {Open: 8.2, Close: 8.9}, {Open: 8.6, Close: 9.4}}},
+	}
+
+	for _, c := range cases {
+
+		p := &Pattern{Formation: c.formation, Delta: 0.01}
+
+		if !p.Matches(c.rates) || p.Matches(c.rates[1:]) {
+			util.PrettyPrint(c.formation)
+			t.Fail()
+		}
+	}
+
+	if (&Pattern{Formation: "unknown"}).Matches([]Rate{down, down, down}) {
+		t.Fail()
+	}
+
+	util.PrettyPrint(Formations())
+}
@@ -0,0 +1,94 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Granularity is the width of a Rate in seconds, limited to the widths the exchange serves.
+type Granularity int64
+
+const (
+	Minute         Granularity = 60
+	FiveMinutes    Granularity = 300
+	FifteenMinutes Granularity = 900
+	Hour           Granularity = 3600
+	SixHours       Granularity = 21600
+	Day            Granularity = 86400
+)
+
+var granularities = map[string]Granularity{
+	"1m":  Minute,
+	"5m":  FiveMinutes,
+	"15m": FifteenMinutes,
+	"1h":  Hour,
+	"6h":  SixHours,
+	"1d":  Day,
+}
+
+// ParseGranularity reads a granularity given in seconds, e.g. 300, or as a width, e.g. 5m.
+func ParseGranularity(s string) (Granularity, error) {
+	if g, ok := granularities[s]; ok {
+		return g, nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		for _, g := range granularities {
+			if g == Granularity(i) {
+				return g, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unsupported granularity %s", s)
+}
+
+func (g Granularity) Duration() time.Duration {
+	return time.Duration(g) * time.Second
+}
+
+// bucket returns the start of the granularity interval a unix second falls in, intervals align to UTC midnight.
+func (g Granularity) bucket(unixSecond int64) int64 {
+	return unixSecond - unixSecond%int64(g)
+}
+
+// aggregates reports whether building this granularity from minute rates takes no more exchange requests
+// than fetching it directly, which is the case when the minute rates in range are mostly stored already.
+func (g Granularity) aggregates(productID string, alpha, omega int64) bool {
+	requests := func(g Granularity) int64 {
+		return (missingRates(productID, alpha, omega, g) + maxCandles - 1) / maxCandles
+	}
+	return requests(Minute) <= requests(g)
+}
+
+// aggregate combines minute rates into rates of the granularity.
+func aggregate(rates []Rate, g Granularity) []Rate {
+
+	var results []Rate
+
+	for _, rate := range rates {
+
+		bucket := g.bucket(rate.UnixSecond)
+
+		if n := len(results); n > 0 && results[n-1].UnixSecond == bucket {
+			r := &results[n-1]
+			r.Close = rate.Close
+			r.Volume += rate.Volume
+			if rate.High > r.High {
+				r.High = rate.High
+			}
+			if rate.Low < r.Low {
+				r.Low = rate.Low
+			}
+			continue
+		}
+
+		rate.UnixSecond = bucket
+		rate.Granularity = g
+		rate.Feed = false
+		rate.Reconciled = false
+		rate.Drift = 0
+		results = append(results, rate)
+	}
+
+	return results
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"nuchal-api/util"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+
+	g, err := ParseGranularity("5m")
+	if err != nil || g != FiveMinutes {
+		t.Fail()
+	}
+
+	if _, err = ParseGranularity("120"); err == nil {
+		t.Fail()
+	}
+
+	var rates []Rate
+	for i := int64(0); i < 10; i++ {
+		rates = append(rates, Rate{
+			UnixSecond:  i * 60,
+			ProductID:   productID,
+			Granularity: Minute,
+			Low:         float64(10 - i),
+			High:        float64(10 + i),
+			Open:        float64(i),
+			Close:       float64(i + 1),
+			Volume:      1,
+		})
+	}
+
+	aggregated := aggregate(rates, g)
+	if len(aggregated) != 2 {
+		t.Fail()
+	}
+
+	r := aggregated[1]
+	if r.UnixSecond != 300 || r.Granularity != g || r.Open != 5 || r.Close != 10 || r.High != 19 || r.Low != 1 || r.Volume != 5 {
+		t.Fail()
+	}
+
+	util.PrettyPrint(aggregated)
+}
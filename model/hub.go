@@ -0,0 +1,634 @@
+package model
+
+import (
+	"errors"
+	ws "github.com/gorilla/websocket"
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Tick is a ticker message received from the feed, stored in the order it was received when recording.
+type Tick struct {
+	ID        uint      `json:"-" gorm:"primarykey"`
+	ProductID string    `json:"product_id" gorm:"index:idx_ticks_time,priority:1"`
+	Price     float64   `json:"price"`
+	BestBid   float64   `json:"best_bid"`
+	BestAsk   float64   `json:"best_ask"`
+	LastSize  float64   `json:"last_size"`
+	Sequence  int64     `json:"sequence"`
+	Time      time.Time `json:"time" gorm:"index:idx_ticks_time,priority:2"`
+}
+
+func newTick(msg cb.Message) Tick {
+	return Tick{
+		ProductID: msg.ProductID,
+		Price:     parseFloat(msg.Price),
+		BestBid:   parseFloat(msg.BestBid),
+		BestAsk:   parseFloat(msg.BestAsk),
+		LastSize:  parseFloat(msg.LastSize),
+		Sequence:  msg.Sequence,
+		Time:      msg.Time.Time(),
+	}
+}
+
+// Hub holds a single feed connection and fans out ticks and minute candles of every subscribed product,
+// keeping the order book of each product a Subscription follows. The connection subscribes to heartbeats, and is redialed with backoff when it
+// fails or stalls.
+type Hub struct {
+	feed         string
+	mu           sync.Mutex
+	conn         *ws.Conn
+	reconnecting bool
+	subs         map[string]map[*Subscription]bool
+	candles      map[string]*candle
+	closed       map[string]time.Time
+	books        map[string]*Book
+}
+
+// Subscription delivers the ticks and closed minute candles of one product, and keeps its order book while it
+// follows the book. Lost is closed when the Hub gives up reconnecting, after which the Subscription receives nothing.
+type Subscription struct {
+	ProductID string
+	Ticks     chan Tick
+	Rates     chan Rate
+	Lost      chan struct{}
+	hub       *Hub
+	book      bool
+}
+
+// candle accumulates the trades of one wall clock minute into a Rate.
+type candle struct {
+	start                          time.Time
+	open, high, low, close, volume float64
+}
+
+func (c *candle) rate(productID string) Rate {
+	return NewRate(productID, cb.HistoricRate{
+		Time:   c.start.UTC(),
+		Low:    c.low,
+		High:   c.high,
+		Open:   c.open,
+		Close:  c.close,
+		Volume: c.volume,
+	})
+}
+
+const (
+
+	// stallTimeout is how long the feed may go without a message, heartbeats arrive every second.
+	stallTimeout = 10 * time.Second
+
+	// minBackoff and maxBackoff bound the delay between reconnect attempts.
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+
+	// maxReconnects is the number of failed attempts after which subscriptions are lost.
+	maxReconnects = 20
+
+	// handshakeTimeout is how long dialing the feed may take before it is abandoned.
+	handshakeTimeout = 10 * time.Second
+)
+
+// dialer connects to feeds, failing a handshake the feed does not complete in time.
+var dialer = ws.Dialer{HandshakeTimeout: handshakeTimeout}
+
+var hubs = struct {
+	sync.Mutex
+	m map[string]*Hub
+}{m: map[string]*Hub{}}
+
+// HubFor returns the process wide Hub of a feed URL.
+func HubFor(feed string) *Hub {
+	hubs.Lock()
+	defer hubs.Unlock()
+	if _, ok := hubs.m[feed]; !ok {
+		hubs.m[feed] = &Hub{
+			feed:    feed,
+			subs:    map[string]map[*Subscription]bool{},
+			candles: map[string]*candle{},
+			closed:  map[string]time.Time{},
+			books:   map[string]*Book{},
+		}
+		go hubs.m[feed].clock()
+	}
+	return hubs.m[feed]
+}
+
+func (h *Hub) log() *zerolog.Logger {
+	logger := log.With().Str("feed", h.feed).Logger()
+	return &logger
+}
+
+// Subscribe connects the Hub if needed, subscribes the product on the feed when it is new, and returns a Subscription.
+// While reconnecting the product is subscribed once the new connection is up.
+func (h *Hub) Subscribe(productID string) (*Subscription, error) {
+	return h.subscribe(productID, false)
+}
+
+// SubscribeBook subscribes as Subscribe does, also following the product's order book on the level2 channel
+// until the Subscription is closed.
+func (h *Hub) SubscribeBook(productID string) (*Subscription, error) {
+	return h.subscribe(productID, true)
+}
+
+func (h *Hub) subscribe(productID string, book bool) (*Subscription, error) {
+
+	h.mu.Lock()
+
+	// dial without the lock so a slow handshake does not hold up dispatch and other subscribers
+	var dialed bool
+	if h.conn == nil && !h.reconnecting {
+
+		h.mu.Unlock()
+		conn, err := h.dial(productID)
+		if err != nil {
+			return nil, err
+		}
+		h.mu.Lock()
+
+		if h.conn == nil && !h.reconnecting {
+			h.conn = conn
+			dialed = true
+			go h.read(conn)
+		} else if err = conn.Close(); err != nil {
+			// connected by another subscriber meanwhile
+			h.log().Err(err).Msg("closing ws")
+		}
+	}
+
+	defer h.mu.Unlock()
+
+	follow := book && !h.following(productID)
+
+	if !dialed && h.conn != nil && len(h.subs[productID]) == 0 {
+		if err := h.conn.WriteJSON(subscription("subscribe", productID)); err != nil {
+			h.log().Err(err).Msg("writing ws")
+			return nil, err
+		}
+		h.log().Debug().Str("productID", productID).Msg("subscribed")
+	}
+
+	if h.subs[productID] == nil {
+		h.subs[productID] = map[*Subscription]bool{}
+	}
+
+	s := &Subscription{
+		ProductID: productID,
+		Ticks:     make(chan Tick, 64),
+		Rates:     make(chan Rate, 4),
+		Lost:      make(chan struct{}),
+		hub:       h,
+		book:      book,
+	}
+
+	h.subs[productID][s] = true
+
+	// a failed write fails the connection's reads too, and the reconnect follows the book
+	if follow && h.conn != nil {
+		if err := h.conn.WriteJSON(bookSubscription("subscribe", productID)); err != nil {
+			h.log().Err(err).Msg("writing ws")
+		} else {
+			h.log().Debug().Str("productID", productID).Msg("following book")
+		}
+	}
+
+	return s, nil
+}
+
+// following reports whether a Subscription of the product follows its order book.
+func (h *Hub) following(productID string) bool {
+	for s := range h.subs[productID] {
+		if s.book {
+			return true
+		}
+	}
+	return false
+}
+
+// Close removes the Subscription, unsubscribing the product from the feed when nobody else needs it.
+func (s *Subscription) Close() error {
+
+	h := s.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.subs[s.ProductID][s] {
+		return nil
+	}
+
+	delete(h.subs[s.ProductID], s)
+
+	unfollow := s.book && !h.following(s.ProductID)
+	if unfollow {
+		delete(h.books, s.ProductID)
+	}
+
+	if unfollow && h.conn != nil {
+		if err := h.conn.WriteJSON(bookSubscription("unsubscribe", s.ProductID)); err != nil {
+			h.log().Err(err).Msg("writing ws")
+			return err
+		}
+		h.log().Debug().Str("productID", s.ProductID).Msg("unfollowed book")
+	}
+
+	if len(h.subs[s.ProductID]) > 0 {
+		return nil
+	}
+
+	delete(h.subs, s.ProductID)
+	delete(h.candles, s.ProductID)
+	delete(h.books, s.ProductID)
+
+	if h.conn == nil {
+		return nil
+	}
+
+	if len(h.subs) == 0 {
+		conn := h.conn
+		h.conn = nil
+		h.log().Debug().Msg("closing")
+		return conn.Close()
+	}
+
+	if err := h.conn.WriteJSON(subscription("unsubscribe", s.ProductID)); err != nil {
+		h.log().Err(err).Msg("writing ws")
+		return err
+	}
+
+	h.log().Debug().Str("productID", s.ProductID).Msg("unsubscribed")
+
+	return nil
+}
+
+// subscription is a feed message for the ticker and heartbeat channels of the given products.
+func subscription(kind string, productIDs ...string) *cb.Message {
+	return &cb.Message{
+		Type: kind,
+		Channels: []cb.MessageChannel{
+			{Name: "ticker", ProductIds: productIDs},
+			{Name: "heartbeat", ProductIds: productIDs},
+		},
+	}
+}
+
+// bookSubscription is a feed message for the level2 channel of the given products, whose order books are followed.
+func bookSubscription(kind string, productIDs ...string) *cb.Message {
+	return &cb.Message{
+		Type:     kind,
+		Channels: []cb.MessageChannel{{Name: "level2", ProductIds: productIDs}},
+	}
+}
+
+// Book returns the order book of a product, which is empty until a Subscription follows it and the feed sends its
+// snapshot.
+func (h *Hub) Book(productID string) *Book {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.books[productID] == nil {
+		h.books[productID] = newBook(productID)
+	}
+	return h.books[productID]
+}
+
+// followed returns the order book of a product while a Subscription follows it, ignoring messages that arrive
+// after the book is unfollowed.
+func (h *Hub) followed(productID string) *Book {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.following(productID) {
+		return nil
+	}
+	if h.books[productID] == nil {
+		h.books[productID] = newBook(productID)
+	}
+	return h.books[productID]
+}
+
+// dial opens a feed connection subscribed to the given products.
+func (h *Hub) dial(productIDs ...string) (*ws.Conn, error) {
+
+	conn, _, err := dialer.Dial(h.feed, nil)
+	if err != nil {
+		h.log().Err(err).Msg("opening ws")
+		return nil, err
+	}
+
+	h.log().Debug().Msg("connected")
+
+	if err = conn.WriteJSON(subscription("subscribe", productIDs...)); err != nil {
+		h.log().Err(err).Msg("writing ws")
+		if err := conn.Close(); err != nil {
+			h.log().Err(err).Msg("closing ws")
+		}
+		return nil, err
+	}
+
+	h.log().Debug().Strs("productIDs", productIDs).Msg("subscribed")
+
+	return conn, nil
+}
+
+// read dispatches feed messages until the connection fails, stalls, or is closed.
+func (h *Hub) read(conn *ws.Conn) {
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(stallTimeout)); err != nil {
+			h.reconnect(conn, err)
+			return
+		}
+		var msg cb.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			h.reconnect(conn, err)
+			return
+		}
+		switch msg.Type {
+		case "ticker":
+			h.dispatch(newTick(msg))
+		case "snapshot":
+			if book := h.followed(msg.ProductID); book != nil {
+				book.snapshot(msg)
+			}
+		case "l2update":
+			if book := h.followed(msg.ProductID); book != nil {
+				book.update(msg)
+			}
+		case "error":
+			h.log().Err(errors.New(msg.Message)).Send()
+		}
+	}
+}
+
+// reconnect redials a failed connection with exponential backoff and jitter, resubscribing every product.
+// A connection closed by the Hub itself is not redialed.
+func (h *Hub) reconnect(conn *ws.Conn, err error) {
+
+	h.mu.Lock()
+	if h.conn != conn {
+		h.mu.Unlock()
+		return
+	}
+	h.log().Err(err).Msg("lost")
+	h.conn = nil
+	h.reconnecting = true
+	for _, book := range h.books {
+		book.markStale()
+	}
+	h.mu.Unlock()
+
+	if err = conn.Close(); err != nil {
+		h.log().Err(err).Msg("closing lost ws")
+	}
+
+	for attempt := 0; ; attempt++ {
+
+		time.Sleep(backoff(attempt))
+
+		h.mu.Lock()
+		var productIDs []string
+		for productID := range h.subs {
+			productIDs = append(productIDs, productID)
+		}
+		if len(productIDs) == 0 {
+			h.reconnecting = false
+			h.mu.Unlock()
+			return
+		}
+		h.mu.Unlock()
+
+		next, err := h.dial(productIDs...)
+
+		h.mu.Lock()
+
+		if err == nil {
+			h.conn = next
+			h.reconnecting = false
+			h.resubscribe(productIDs)
+			h.mu.Unlock()
+			h.log().Info().Int("attempt", attempt+1).Msg("reconnected")
+			go h.read(next)
+			return
+		}
+
+		if attempt+1 == maxReconnects {
+			h.log().Error().Int("attempt", attempt+1).Msg("giving up")
+			for productID, subs := range h.subs {
+				for s := range subs {
+					close(s.Lost)
+				}
+				delete(h.subs, productID)
+			}
+			h.candles = map[string]*candle{}
+			h.books = map[string]*Book{}
+			h.reconnecting = false
+			h.mu.Unlock()
+			return
+		}
+
+		h.mu.Unlock()
+	}
+}
+
+// resubscribe aligns a new connection, dialed with the given products, with subscriptions changed while dialing,
+// and follows the order books that Subscriptions follow.
+func (h *Hub) resubscribe(dialed []string) {
+
+	was := map[string]bool{}
+	for _, productID := range dialed {
+		was[productID] = true
+		if h.subs[productID] == nil {
+			if err := h.conn.WriteJSON(subscription("unsubscribe", productID)); err != nil {
+				h.log().Err(err).Msg("writing ws")
+			}
+		}
+	}
+
+	var books []string
+	for productID := range h.subs {
+		if !was[productID] {
+			if err := h.conn.WriteJSON(subscription("subscribe", productID)); err != nil {
+				h.log().Err(err).Msg("writing ws")
+			}
+		}
+		if h.following(productID) {
+			books = append(books, productID)
+		}
+	}
+
+	if len(books) > 0 {
+		if err := h.conn.WriteJSON(bookSubscription("subscribe", books...)); err != nil {
+			h.log().Err(err).Msg("writing ws")
+		}
+	}
+}
+
+// backoff doubles from minBackoff up to maxBackoff per attempt, jittered to between half and all of it.
+func backoff(attempt int) time.Duration {
+	d := maxBackoff
+	if attempt < 16 {
+		if d = minBackoff << uint(attempt); d > maxBackoff {
+			d = maxBackoff
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (h *Hub) dispatch(tick Tick) {
+
+	recordTick(tick)
+	tickPapers(h.feed, tick.ProductID, tick.Price)
+
+	h.mu.Lock()
+
+	rate, closed := h.build(tick)
+
+	if closed {
+		h.publish(rate)
+	}
+
+	for s := range h.subs[tick.ProductID] {
+		offerTick(s.Ticks, tick)
+	}
+
+	h.mu.Unlock()
+
+	if closed {
+		saveFeedRate(rate)
+	}
+}
+
+// publish offers a closed Rate to every Subscription of its product.
+func (h *Hub) publish(rate Rate) {
+	for s := range h.subs[rate.ProductID] {
+		offerRate(s.Rates, rate)
+	}
+}
+
+// build adds a trade to the candle of the minute it happened in, using last size as volume.
+// A trade from a later minute closes the open candle and returns its Rate, a late trade joins the open candle,
+// and a trade from a minute already closed, e.g. one that arrives just after the clock's boundary, is left out.
+func (h *Hub) build(tick Tick) (Rate, bool) {
+
+	at := tick.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	start := at.Truncate(time.Minute)
+
+	if last, ok := h.closed[tick.ProductID]; ok && !start.After(last) {
+		h.log().Debug().Str("productID", tick.ProductID).Time("time", at).Msg("late tick")
+		return Rate{}, false
+	}
+
+	var rate Rate
+	var closed bool
+
+	c := h.candles[tick.ProductID]
+	if c != nil && start.After(c.start) {
+		rate, closed = c.rate(tick.ProductID), true
+		h.closed[tick.ProductID] = c.start
+		c = nil
+	}
+
+	if c == nil {
+		c = &candle{
+			start: start,
+			open:  tick.Price,
+			high:  tick.Price,
+			low:   tick.Price,
+		}
+		h.candles[tick.ProductID] = c
+	}
+
+	c.volume += tick.LastSize
+	c.close = tick.Price
+	if c.high < tick.Price {
+		c.high = tick.Price
+	}
+	if c.low > tick.Price {
+		c.low = tick.Price
+	}
+
+	return rate, closed
+}
+
+// clock closes every open candle on each minute boundary. A minute without trades has no candle,
+// matching the exchange, so subscribers receive the next Rate once trading resumes.
+func (h *Hub) clock() {
+	for {
+
+		boundary := time.Now().Truncate(time.Minute).Add(time.Minute)
+		time.Sleep(time.Until(boundary))
+
+		for _, rate := range h.closeBefore(boundary) {
+			saveFeedRate(rate)
+		}
+	}
+}
+
+// closeBefore closes and publishes the open candles that started before the boundary, returning their Rates.
+func (h *Hub) closeBefore(boundary time.Time) []Rate {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var rates []Rate
+	for productID, c := range h.candles {
+		if c.start.Before(boundary) {
+			rate := c.rate(productID)
+			h.closed[productID] = c.start
+			delete(h.candles, productID)
+			h.publish(rate)
+			rates = append(rates, rate)
+		}
+	}
+	return rates
+}
+
+// open returns the Rate of a product's candle still open.
+func (h *Hub) open(productID string) (Rate, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c := h.candles[productID]; c != nil {
+		return c.rate(productID), true
+	}
+	return Rate{}, false
+}
+
+// offerTick sends without blocking the Hub, dropping the oldest tick of a full channel.
+func offerTick(ch chan Tick, tick Tick) {
+	for {
+		select {
+		case ch <- tick:
+			return
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+		}
+	}
+}
+
+// offerRate sends without blocking the Hub, dropping the oldest rate of a full channel.
+func offerRate(ch chan Rate, rate Rate) {
+	for {
+		select {
+		case ch <- rate:
+			return
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+		}
+	}
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"net"
+	"nuchal-api/util"
+	"testing"
+	"time"
+)
+
+func TestHubFor(t *testing.T) {
+
+	a, err := HubFor(environment.Feed).Subscribe(productID)
+	if err != nil {
+		t.Fail()
+		return
+	}
+
+	b, err := HubFor(environment.Feed).Subscribe(productID)
+	if err != nil {
+		t.Fail()
+		return
+	}
+
+	util.PrettyPrint(<-a.Ticks)
+	util.PrettyPrint(<-b.Ticks)
+
+	if err = a.Close(); err != nil {
+		t.Fail()
+	}
+
+	if err = b.Close(); err != nil {
+		t.Fail()
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < maxReconnects; attempt++ {
+		if d := backoff(attempt); d < minBackoff/2 || d > maxBackoff {
+			t.Fail()
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+
+	h := &Hub{candles: map[string]*candle{}, closed: map[string]time.Time{}}
+	minute := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, price := range []float64{1, 3, 0.5, 2} {
+		if _, closed := h.build(Tick{ProductID: productID, Price: price, LastSize: 1, Time: minute.Add(time.Duration(i) * 10 * time.Second)}); closed {
+			t.Fail()
+		}
+	}
+
+	rate, closed := h.build(Tick{ProductID: productID, Price: 5, LastSize: 2, Time: minute.Add(61 * time.Second)})
+	if !closed || rate.UnixSecond != minute.Unix() || rate.High != 3 || rate.Low != 0.5 || rate.Close != 2 || rate.Volume != 4 {
+		t.Fail()
+	}
+
+	util.PrettyPrint(rate)
+}
+
+func TestBuildLate(t *testing.T) {
+
+	h := &Hub{candles: map[string]*candle{}, closed: map[string]time.Time{}}
+	minute := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	h.build(Tick{ProductID: productID, Price: 1, LastSize: 1, Time: minute.Add(50 * time.Second)})
+
+	if rates := h.closeBefore(minute.Add(time.Minute)); len(rates) != 1 || rates[0].Close != 1 {
+		t.Fail()
+	}
+
+	// a tick of the closed minute arriving just after the boundary
+	if _, closed := h.build(Tick{ProductID: productID, Price: 2, LastSize: 1, Time: minute.Add(59 * time.Second)}); closed {
+		t.Fail()
+	}
+
+	if _, ok := h.open(productID); ok {
+		t.Fail()
+	}
+
+	h.build(Tick{ProductID: productID, Price: 3, LastSize: 1, Time: minute.Add(61 * time.Second)})
+
+	rate, ok := h.open(productID)
+	if !ok || rate.UnixSecond != minute.Add(time.Minute).Unix() || rate.Open != 3 || rate.Volume != 1 {
+		t.Fail()
+	}
+
+	util.PrettyPrint(rate)
+}
+
+func TestSubscribeBook(t *testing.T) {
+
+	// while reconnecting a Hub subscribes without writing to the feed
+	h := &Hub{subs: map[string]map[*Subscription]bool{}, candles: map[string]*candle{}, closed: map[string]time.Time{}, books: map[string]*Book{}, reconnecting: true}
+
+	ticker, _ := h.Subscribe(productID)
+	if h.followed(productID) != nil {
+		t.Fail()
+	}
+
+	book, _ := h.SubscribeBook(productID)
+	if h.followed(productID) == nil {
+		t.Fail()
+	}
+
+	if err := book.Close(); err != nil || h.followed(productID) != nil || h.books[productID] != nil {
+		t.Fail()
+	}
+
+	if err := ticker.Close(); err != nil || len(h.subs) != 0 {
+		t.Fail()
+	}
+}
+
+func TestSubscribeDialUnlocked(t *testing.T) {
+
+	// a feed that accepts connections and never completes the handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	h := &Hub{feed: "ws://" + l.Addr().String(), subs: map[string]map[*Subscription]bool{}, candles: map[string]*candle{}, closed: map[string]time.Time{}, books: map[string]*Book{}}
+
+	failed := make(chan error, 1)
+	go func() {
+		_, err := h.Subscribe(productID)
+		failed <- err
+	}()
+
+	conn := <-accepted
+
+	locked := make(chan bool)
+	go func() {
+		h.mu.Lock()
+		h.mu.Unlock()
+		locked <- true
+	}()
+
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fail()
+	}
+
+	conn.Close()
+
+	if err = <-failed; err == nil || h.conn != nil {
+		t.Fail()
+	}
+}
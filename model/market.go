@@ -0,0 +1,90 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Market is the public market data of a venue, which needs no credentials.
+type Market interface {
+	GetCurrencies() ([]cb.Currency, error)
+	GetProducts() ([]cb.Product, error)
+	GetHistoricRates(productID string, params cb.GetHistoricRatesParams) ([]cb.HistoricRate, error)
+}
+
+// market reads public market data with plain unsigned requests, so shared data never depends on a user's keys.
+type market struct {
+	rest   string
+	client *http.Client
+}
+
+var marketClient = &http.Client{Timeout: 15 * time.Second}
+
+// marketData is the Market of the environment endpoint.
+func marketData() Market {
+	return &market{environment.Rest, marketClient}
+}
+
+func (m *market) GetCurrencies() ([]cb.Currency, error) {
+	var currencies []cb.Currency
+	return currencies, m.get("/currencies", nil, &currencies)
+}
+
+func (m *market) GetProducts() ([]cb.Product, error) {
+	var products []cb.Product
+	return products, m.get("/products", nil, &products)
+}
+
+func (m *market) GetHistoricRates(productID string, params cb.GetHistoricRatesParams) ([]cb.HistoricRate, error) {
+
+	values := url.Values{}
+	if !params.Start.IsZero() {
+		values.Add("start", params.Start.UTC().Format(time.RFC3339))
+	}
+	if !params.End.IsZero() {
+		values.Add("end", params.End.UTC().Format(time.RFC3339))
+	}
+	if params.Granularity != 0 {
+		values.Add("granularity", strconv.Itoa(params.Granularity))
+	}
+
+	var rates []cb.HistoricRate
+	return rates, m.get(fmt.Sprintf("/products/%s/candles", productID), values, &rates)
+}
+
+// get decodes the response of a public endpoint, or the venue's error message as a cb.Error.
+func (m *market) get(path string, values url.Values, result interface{}) error {
+
+	u := m.rest + path
+	if len(values) > 0 {
+		u += "?" + values.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("User-Agent", "nuchal")
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		cbErr := cb.Error{}
+		if err = json.NewDecoder(res.Body).Decode(&cbErr); err != nil || cbErr.Message == "" {
+			return fmt.Errorf("%s %s", path, res.Status)
+		}
+		return cbErr
+	}
+
+	return json.NewDecoder(res.Body).Decode(result)
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"net/http"
+	"net/http/httptest"
+	"nuchal-api/util"
+	"testing"
+	"time"
+)
+
+func TestMarket(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("CB-ACCESS-KEY") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/products/ALGO-USD/candles":
+			if r.URL.Query().Get("granularity") != "60" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`[[1633046460,1,1.2,1.05,1.1,100],[1633046400,0.9,1.1,1,1.05,100]]`))
+		default:
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"message":"Public rate limit exceeded"}`))
+		}
+	}))
+	defer ts.Close()
+
+	m := &market{ts.URL, ts.Client()}
+
+	rates, err := m.GetHistoricRates("ALGO-USD", cb.GetHistoricRatesParams{
+		Start:       time.Unix(1633046400, 0),
+		End:         time.Unix(1633046460, 0),
+		Granularity: 60,
+	})
+	if err != nil || len(rates) != 2 || rates[1].Close != 1.05 {
+		t.Fail()
+	}
+
+	if _, err = m.GetProducts(); !isRateLimited(err) {
+		t.Fail()
+	}
+
+	util.PrettyPrint(rates)
+}
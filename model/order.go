@@ -1,12 +1,24 @@
 package model
 
 import (
+	"fmt"
 	cb "github.com/preichenberger/go-coinbasepro/v2"
 	"github.com/rs/zerolog/log"
 	"nuchal-api/util"
 	"time"
 )
 
+const (
+	// fillTimeout is how long an entry waits for its market order to fill.
+	fillTimeout = 30 * time.Second
+
+	// fillPoll is how often an unfilled order is checked.
+	fillPoll = 500 * time.Millisecond
+
+	// limitPoll is how often a resting take profit limit is checked for a fill.
+	limitPoll = 5 * time.Second
+)
+
 type SellOrder struct {
 	ID                  string   `json:"id"`
 	CreatedAtUnixSecond int64    `json:"created_at"`
@@ -96,6 +108,30 @@ func GetOrder(pattern Pattern, orderID string, attempt ...int) (cb.Order, error)
 	return order, nil
 }
 
+// awaitFill polls an order until it is done with a filled size, which an order for funds only has once it fills.
+func awaitFill(client Exchange, orderID string, timeout time.Duration) (cb.Order, error) {
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+
+		order, err := client.GetOrder(orderID)
+		if err != nil {
+			return cb.Order{}, err
+		}
+
+		if (order.Status == "done" || order.Settled) && util.StringToFloat64(order.FilledSize) > 0 {
+			return order, nil
+		}
+
+		if time.Now().After(deadline) {
+			return cb.Order{}, fmt.Errorf("order %s did not fill within %s", orderID, timeout)
+		}
+
+		time.Sleep(fillPoll)
+	}
+}
+
 // CancelOrder is a recursive function that cancels an order equal to the given id.
 func CancelOrder(pattern Pattern, orderID string, attempt ...int) error {
 
@@ -129,24 +165,20 @@ func CancelOrder(pattern Pattern, orderID string, attempt ...int) error {
 func GetOrders(userID uint, product Product) ([]SellOrder, error) {
 	u := FindUserByID(userID)
 	var sellOrders []SellOrder
-	var orders []cb.Order
-	cursor := u.Client().ListOrders(cb.ListOrdersParams{ProductID: product.ID})
-	for cursor.HasMore {
-		if err := cursor.NextPage(&orders); err != nil {
-			return nil, err
-		}
-		for _, order := range orders {
-			sellOrders = append(sellOrders, SellOrder{
-				ID:                  order.ID,
-				CreatedAtUnixSecond: order.CreatedAt.Time().UTC().Unix(),
-				Price:               util.StringToFloat64(order.Price),
-				PriceText:           "$" + product.precise(util.StringToFloat64(order.Price)),
-				Size:                util.StringToFloat64(order.Size),
-				SizeText:            product.precise(util.StringToFloat64(order.Size)),
-				Order:               order,
-			})
-		}
-
+	orders, err := u.Client().ListOrders(product.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		sellOrders = append(sellOrders, SellOrder{
+			ID:                  order.ID,
+			CreatedAtUnixSecond: order.CreatedAt.Time().UTC().Unix(),
+			Price:               util.StringToFloat64(order.Price),
+			PriceText:           "$" + product.precise(util.StringToFloat64(order.Price)),
+			Size:                util.StringToFloat64(order.Size),
+			SizeText:            product.precise(util.StringToFloat64(order.Size)),
+			Order:               order,
+		})
 	}
 	return sellOrders, nil
 }
@@ -169,6 +201,11 @@ func DeleteOrder(userID uint, orderID string) (err error) {
 
 func DeleteOrders(userID uint, productID string) (err error) {
 	u := FindUserByID(userID)
-	_, err = u.Client().CancelAllOrders(cb.CancelAllOrdersParams{ProductID: productID})
+	_, err = u.Client().CancelAllOrders(productID)
 	return
 }
+
+// notFound reports whether the exchange has no such order, as it does for an order canceled before anything filled.
+func notFound(err error) bool {
+	return err.Error() == "NotFound"
+}
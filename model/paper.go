@@ -0,0 +1,165 @@
+package model
+
+import (
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"github.com/rs/zerolog/log"
+	"nuchal-api/db"
+	"sync"
+)
+
+// PaperLedger persists the simulated balances, orders and fills of a paper trading user.
+type PaperLedger struct {
+	UserID    uint   `json:"user_id" gorm:"primarykey"`
+	CreatedAt int64  `json:"created_at" gorm:"autoCreateTime:nano"`
+	UpdatedAt int64  `json:"updated_at" gorm:"autoUpdateTime:nano"`
+	State     string `json:"state"`
+}
+
+// paper is the simulated matching engine used in place of Coinbase when a User trades on paper.
+// Market orders fill at the latest Pipe price, and stop orders trigger as the ticker of its feed crosses them.
+type paper struct {
+	*FakeExchange
+	userID uint
+	feed   string
+	market Exchange
+
+	// saves asks the engine's saver to persist its ledger, coalescing requests made while it is saving.
+	saves chan struct{}
+}
+
+var papers = struct {
+	sync.Mutex
+	m map[uint]*paper
+}{m: map[uint]*paper{}}
+
+func init() {
+	db.Migrate(&PaperLedger{})
+}
+
+// paperFor returns the paper engine of the given user, loading its ledger the first time it is used
+// and charging the user's current fees.
+func paperFor(u *User) *paper {
+
+	papers.Lock()
+	defer papers.Unlock()
+
+	if p, ok := papers.m[u.ID]; ok {
+		p.SetFees(u.Maker, u.Taker)
+		return p
+	}
+
+	p := &paper{NewFakeExchange(u.Maker, u.Taker), u.ID, u.Endpoint().Feed, u.Api.Client(), make(chan struct{}, 1)}
+
+	var ledger PaperLedger
+	db.Resolve().Where("user_id = ?", u.ID).Find(&ledger)
+
+	if ledger.State == "" {
+		p.Deposit("USD", u.PaperCash)
+	} else if err := p.restore([]byte(ledger.State)); err != nil {
+		log.Err(err).Uint("userID", u.ID).Msg("restoring paper ledger")
+	}
+
+	papers.m[u.ID] = p
+
+	go p.saver()
+
+	return p
+}
+
+// tickPapers moves the price of a product on every paper engine trading on the feed, filling any orders the price
+// crosses.
+func tickPapers(feed, productID string, price float64) {
+
+	papers.Lock()
+	var engines []*paper
+	for _, p := range papers.m {
+		if p.feed == feed {
+			engines = append(engines, p)
+		}
+	}
+	papers.Unlock()
+
+	for _, p := range engines {
+		p.mu.Lock()
+		filled := p.setPrice(productID, price)
+		p.mu.Unlock()
+		if filled > 0 {
+			p.save()
+		}
+	}
+}
+
+// save asks the saver to persist the ledger without waiting for the database.
+func (p *paper) save() {
+	select {
+	case p.saves <- struct{}{}:
+	default:
+	}
+}
+
+// saver persists the ledger one save at a time, each reading the state as of when it runs, so a later save never
+// loses to an earlier one.
+func (p *paper) saver() {
+	for range p.saves {
+
+		p.mu.Lock()
+		data, err := p.state()
+		p.mu.Unlock()
+
+		if err != nil {
+			log.Err(err).Uint("userID", p.userID).Msg("saving paper ledger")
+			continue
+		}
+
+		if err = db.Resolve().Save(&PaperLedger{UserID: p.userID, State: string(data)}).Error; err != nil {
+			log.Err(err).Uint("userID", p.userID).Msg("saving paper ledger")
+		}
+	}
+}
+
+// CreateOrder prices the product from the latest stored rate when no tick has been seen yet.
+func (p *paper) CreateOrder(order *cb.Order) (cb.Order, error) {
+
+	if p.Price(order.ProductID) == 0 {
+		var rate Rate
+		FindFirstRateByProductIDInTimeDescOrder(order.ProductID, &rate)
+		if rate.Close > 0 {
+			p.SetPrice(order.ProductID, rate.Close)
+		}
+	}
+
+	o, err := p.FakeExchange.CreateOrder(order)
+	if err == nil {
+		p.save()
+	}
+	return o, err
+}
+
+func (p *paper) CancelOrder(orderID string) error {
+	err := p.FakeExchange.CancelOrder(orderID)
+	if err == nil {
+		p.save()
+	}
+	return err
+}
+
+func (p *paper) CancelAllOrders(productID string) ([]string, error) {
+	ids, err := p.FakeExchange.CancelAllOrders(productID)
+	if err == nil {
+		p.save()
+	}
+	return ids, err
+}
+
+// GetCurrencies reads public data from the Venue, simulation only applies to trading.
+func (p *paper) GetCurrencies() ([]cb.Currency, error) {
+	return p.market.GetCurrencies()
+}
+
+func (p *paper) GetProducts() ([]cb.Product, error) {
+	return p.market.GetProducts()
+}
+
+func (p *paper) GetHistoricRates(productID string, params cb.GetHistoricRatesParams) ([]cb.HistoricRate, error) {
+	return p.market.GetHistoricRates(productID, params)
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"nuchal-api/util"
+	"testing"
+)
+
+func TestPaperFor(t *testing.T) {
+
+	u := FindUserByID(userID)
+	u.Paper = true
+	u.PaperCash = 1000
+
+	p := paperFor(&u)
+	tickPapers(u.Endpoint().Feed, productID, 1)
+
+	product := Product{StrModel: StrModel{ID: productID}}
+	order := product.NewMarketEntryOrder("10")
+	if _, err := p.CreateOrder(&order); err != nil {
+		t.Fail()
+	}
+
+	accounts, err := u.Client().GetAccounts()
+	if err != nil {
+		t.Fail()
+	}
+
+	util.PrettyPrint(accounts)
+}
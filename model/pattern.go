@@ -4,6 +4,7 @@ import (
 	cb "github.com/preichenberger/go-coinbasepro/v2"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
 	"math"
 	"nuchal-api/db"
 	"nuchal-api/util"
@@ -26,6 +27,9 @@ type Pattern struct {
 	// UserID
 	UserID uint `json:"user_id"`
 
+	// Revision is the version of the pattern last saved, recorded by the sessions and sims that use it.
+	Revision int `json:"revision"`
+
 	// Currency is concatenation of two currencies. e.g. BTC-USD
 	ProductID string `json:"product_id" gorm:"product_id"`
 
@@ -38,9 +42,27 @@ type Pattern struct {
 	// Size is the amount of the transaction, using the ProductMap native quote increment.
 	Size float64 `json:"size"`
 
+	// Sizing is how Size sizes each entry, a base currency amount by default.
+	Sizing Sizing `json:"sizing" gorm:"default:base"`
+
 	// Delta is the size of an acceptable difference between tweezer bottom candlesticks.
 	Delta float64 `json:"delta"`
 
+	// Formation is the candlestick formation that triggers entry, a tweezer bottom by default.
+	Formation Formation `json:"formation" gorm:"default:tweezer_bottom"`
+
+	// Filters are indicator conditions that must all hold, as well as the formation, for the pattern to enter.
+	Filters []Filter `json:"filters"`
+
+	// Entry is a rule that must also hold for the pattern to enter, e.g. close < bb_lower(20,2) and rsi(14) < 25.
+	Entry string `json:"entry"`
+
+	// Exit is a rule that sells at market once it holds on a closed rate after entry.
+	Exit string `json:"exit"`
+
+	// Strategy is how a trade exits once the pattern has entered, climbing from the goal by default.
+	Strategy Strategy `json:"strategy" gorm:"embedded;embeddedPrefix:strategy_"`
+
 	// Bound is the context to which this strategy looks to achieve so that it can break.
 	Bound BoundType `json:"bound"`
 
@@ -92,16 +114,28 @@ func (p *Pattern) MatchesTweezerBottomPattern(then, that, this Rate) bool {
 		math.Abs(math.Min(that.Low, that.Close)-math.Min(this.Low, this.Open)) <= p.Delta
 }
 
-func (p *Pattern) Save() {
-	if p.ID > 0 {
-		db.Resolve().Save(p)
-	} else {
-		db.Resolve().Create(p)
-	}
+// Save saves the pattern, its filters and its next revision together.
+func (p *Pattern) Save() error {
+	return db.Resolve().Transaction(func(tx *gorm.DB) error {
+		var err error
+		if p.ID > 0 {
+			err = tx.Omit("Filters").Save(p).Error
+		} else {
+			err = tx.Omit("Filters").Create(p).Error
+		}
+		if err != nil {
+			return err
+		}
+		if err = p.saveFilters(tx); err != nil {
+			return err
+		}
+		return p.revise(tx)
+	})
 }
 
 func DeletePattern(patternID uint) {
 	db.Resolve().Delete(&Pattern{}, patternID)
+	db.Resolve().Unscoped().Where("pattern_id = ?", patternID).Delete(&Filter{})
 }
 
 func FindPatternByID(patternID uint) Pattern {
@@ -109,6 +143,7 @@ func FindPatternByID(patternID uint) Pattern {
 	db.Resolve().
 		Preload("Product").
 		Preload("User").
+		Preload("Filters").
 		Where("id = ?", patternID).
 		Find(&pattern)
 	return pattern
@@ -119,6 +154,7 @@ func FindFirstPatternByProductID(productID string) Pattern {
 
 	db.Resolve().
 		Preload("User").
+		Preload("Filters").
 		Preload("Product").
 		Where("product_id = ?", productID).
 		First(&pattern)
@@ -133,14 +169,30 @@ func GetPatterns(userID uint) []Pattern {
 	db.Resolve().
 		Preload("Product").
 		Preload("User").
+		Preload("Filters").
 		Where("user_id = ?", userID).
 		Find(&patterns)
 
+	var balances map[string]float64
+	for _, pattern := range patterns {
+		if pattern.needsBalance() {
+			u := FindUserByID(userID)
+			var err error
+			if balances, err = u.balances(); err != nil {
+				log.Err(err).Stack().Send()
+			}
+			break
+		}
+	}
+
 	var newPatterns []Pattern
 	for _, pattern := range patterns {
 
+		price := pattern.Product.Posture.Price
+		size := pattern.baseSize(price, balances[pattern.Product.Quote])
+
 		var buy float64
-		if buy = pattern.Product.Posture.Price * pattern.Size; math.IsNaN(buy) {
+		if buy = price * size; math.IsNaN(buy) {
 			buy = 0
 		}
 
@@ -174,6 +226,7 @@ func FindPattern(id uint) Pattern {
 	db.Resolve().
 		Preload("Product").
 		Preload("User").
+		Preload("Filters").
 		Where("id = ?", id).
 		Find(&pattern)
 	return pattern
@@ -1,21 +1,24 @@
 package model
 
 import (
-	"fmt"
-	ws "github.com/gorilla/websocket"
-	"github.com/pkg/errors"
-	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"nuchal-api/util"
-	"time"
 )
 
+// Pipe reads the prices and minute rates of a product through the shared Hub of a feed, or from recorded ticks.
 type Pipe struct {
-	wsConn    *ws.Conn
+	sub       *Subscription
+	feed      string
 	productID string
+	replaying bool
+
+	// book is whether the pipe follows the product's order book for as long as it is open.
+	book bool
 }
 
+var errPipeLost = errors.New("feed connection lost")
+
 func (p *Pipe) log() *zerolog.Logger {
 	logger := log.Hook(p)
 	return &logger
@@ -25,8 +28,16 @@ func (p *Pipe) Run(event *zerolog.Event, level zerolog.Level, msg string) {
 	event.Str("productID", p.productID)
 }
 
-func NewPipe(productID string) (*Pipe, error) {
-	p := &Pipe{productID: productID}
+func NewPipe(feed, productID string) (*Pipe, error) {
+	return newPipe(&Pipe{feed: feed, productID: productID})
+}
+
+// NewBookPipe opens a Pipe that also follows the product's order book, so its spread is at hand when needed.
+func NewBookPipe(feed, productID string) (*Pipe, error) {
+	return newPipe(&Pipe{feed: feed, productID: productID, book: true})
+}
+
+func newPipe(p *Pipe) (*Pipe, error) {
 	if err := p.Open(); err != nil {
 		log.Err(err).Stack().Send()
 		return nil, err
@@ -36,21 +47,20 @@ func NewPipe(productID string) (*Pipe, error) {
 
 func (p *Pipe) Open() error {
 
-	var wsDialer ws.Dialer
+	// a replay cannot be reopened once its ticks run out
+	if p.replaying {
+		return errReplayDone
+	}
+
 	var err error
 
-	if p.wsConn, _, err = wsDialer.Dial("wss://ws-feed.pro.coinbase.com", nil); err != nil {
-		log.Err(err).Msg("opening ws")
-		return err
+	subscribe := HubFor(p.feed).Subscribe
+	if p.book {
+		subscribe = HubFor(p.feed).SubscribeBook
 	}
 
-	p.log().Debug().Msg("connected")
-
-	if err = p.wsConn.WriteJSON(&cb.Message{
-		Type:     "subscribe",
-		Channels: []cb.MessageChannel{{"ticker", []string{p.productID}}},
-	}); err != nil {
-		log.Err(err).Msg("writing ws")
+	if p.sub, err = subscribe(p.productID); err != nil {
+		p.log().Err(err).Msg("subscribing")
 		return err
 	}
 
@@ -60,7 +70,7 @@ func (p *Pipe) Open() error {
 }
 
 func (p *Pipe) Close() error {
-	if err := p.wsConn.Close(); err != nil {
+	if err := p.sub.Close(); err != nil {
 		p.log().Err(err).Stack().Send()
 		return err
 	}
@@ -84,63 +94,78 @@ func (p *Pipe) Reopen() error {
 // getPrice gets the latest ticker price for the given productId.
 func (p *Pipe) getPrice() (float64, error) {
 
-	var receivedMessage cb.Message
+	var tick Tick
+	var ok bool
+
+	// skip to the most recent of any ticks that arrived since the last read
 	for {
-		if err := p.wsConn.ReadJSON(&receivedMessage); err != nil {
-			p.log().Err(err).Stack().Send()
-			return 0, err
-		}
-		if receivedMessage.Type != "subscriptions" {
-			break
+		select {
+		case next := <-p.sub.Ticks:
+			tick, ok = next, true
+			continue
+		default:
 		}
+		break
 	}
 
-	if receivedMessage.Type != "ticker" {
-		err := errors.New(fmt.Sprintf("message type != ticker, %v", receivedMessage))
-		p.log().Err(err).Stack().Send()
-		return 0, err
+	if ok {
+		return tick.Price, nil
 	}
 
-	return util.StringToFloat64(receivedMessage.Price), nil
+	select {
+	case tick = <-p.sub.Ticks:
+		return tick.Price, nil
+	case <-p.sub.Lost:
+		p.log().Err(errPipeLost).Send()
+		return 0, errPipeLost
+	}
 }
 
-func (p *Pipe) getRate() (Rate, error) {
-
-	end := time.Now().Add(time.Minute)
+// getSpread gets the best bid and ask of the order book the pipe follows without waiting for it, failing when the
+// pipe does not follow the book, the book has no snapshot yet, or the book went stale with the feed.
+func (p *Pipe) getSpread() (float64, float64, error) {
+	if p.replaying || !p.book {
+		return 0, 0, errBookEmpty
+	}
+	book := p.sub.hub.followed(p.productID)
+	if book == nil {
+		return 0, 0, errBookEmpty
+	}
+	return book.Spread()
+}
 
-	var err error
-	var price, low, high, open, volume float64
+// closedRates gets the minute rates that closed since the last read without waiting for another.
+func (p *Pipe) closedRates() []Rate {
+	var rates []Rate
 	for {
-
-		if price, err = p.getPrice(); err != nil {
-			p.log().Err(err).Stack().Send()
-			return Rate{}, err
+		select {
+		case rate := <-p.sub.Rates:
+			rates = append(rates, rate)
+			continue
+		default:
 		}
+		return rates
+	}
+}
 
-		volume++
+// getRate gets the next minute rate to close after the call.
+func (p *Pipe) getRate() (Rate, error) {
 
-		if low == 0 {
-			low = price
-			high = price
-			open = price
-		} else if high < price {
-			high = price
-		} else if low > price {
-			low = price
+	// discard rates that closed before the call
+	for {
+		select {
+		case <-p.sub.Rates:
+			continue
+		default:
 		}
+		break
+	}
 
-		if time.Now().After(end) {
-
-			rate := NewRate(p.productID, cb.HistoricRate{
-				Time:   time.Now().UTC(),
-				Low:    low,
-				High:   high,
-				Open:   open,
-				Close:  price,
-				Volume: volume,
-			})
-
-			return rate, nil
-		}
+	select {
+	case rate := <-p.sub.Rates:
+		return rate, nil
+	case <-p.sub.Lost:
+		p.log().Err(errPipeLost).Send()
+		return Rate{}, errPipeLost
 	}
 }
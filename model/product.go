@@ -59,6 +59,7 @@ func (p *Product) AfterFind(tx *gorm.DB) (err error) {
 	var omegaRate Rate
 
 	tx.Where("product_id = ?", p.ID).
+		Where("granularity = ?", Minute).
 		Order("unix_second desc").
 		First(&omegaRate)
 
@@ -67,6 +68,7 @@ func (p *Product) AfterFind(tx *gorm.DB) (err error) {
 
 	var rates []Rate
 	tx.Where("product_id = ?", p.ID).
+		Where("granularity = ?", Minute).
 		Where("unix_second between ? and ?", alphaTime.Unix(), omegaTime.Unix()).
 		Find(&rates)
 
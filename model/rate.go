@@ -24,13 +24,17 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"nuchal-api/db"
+	"nuchal-api/indicator"
+	"strconv"
 	"time"
 )
 
 type Rate struct {
-	UnixSecond int64  `json:"unix_second" gorm:"primarykey"`
-	ProductID  string `json:"product_id" gorm:"primarykey"`
+	UnixSecond  int64       `json:"unix_second" gorm:"primarykey;index:idx_rates_range,priority:3"`
+	ProductID   string      `json:"product_id" gorm:"primarykey;index:idx_rates_range,priority:1"`
+	Granularity Granularity `json:"granularity" gorm:"primarykey;default:60;index:idx_rates_range,priority:2"`
 
 	CreatedAt int64          `json:"created_at" gorm:"autoCreateTime:nano"`
 	UpdatedAt int64          `json:"updated_at" gorm:"autoUpdateTime:nano"`
@@ -42,22 +46,69 @@ type Rate struct {
 	Close   float64 `json:"close"`
 	Volume  float64 `json:"volume"`
 	Product Product `json:"product"`
+
+	// Feed is true when the rate was built from the ticker feed rather than fetched from the exchange.
+	Feed bool `json:"feed"`
+
+	// Reconciled is true once a feed rate has been compared with the exchange candle of the same minute.
+	Reconciled bool `json:"reconciled"`
+
+	// Drift is the exchange close minus the feed close, recorded when a feed rate is reconciled.
+	Drift float64 `json:"drift"`
 }
 
+// maxCandles is the most candles the exchange returns per request.
+const maxCandles = 300
+
+// rateBatchSize is how many rates are upserted per statement, set with RATE_BATCH_SIZE.
+var rateBatchSize = 1000
+
+// maxRateBatchSize keeps a batch of 14 column rates under the 65535 parameters postgres allows a statement.
+const maxRateBatchSize = 4000
+
 func NewRate(productID string, rate cb.HistoricRate) Rate {
+	return newGranularRate(productID, rate, Minute)
+}
+
+func newGranularRate(productID string, rate cb.HistoricRate, granularity Granularity) Rate {
 	return Rate{
-		ProductID:  productID,
-		UnixSecond: rate.Time.Unix(),
-		Low:        rate.Low,
-		High:       rate.High,
-		Open:       rate.Open,
-		Close:      rate.Close,
-		Volume:     rate.Volume,
+		ProductID:   productID,
+		UnixSecond:  rate.Time.Unix(),
+		Granularity: granularity,
+		Low:         rate.Low,
+		High:        rate.High,
+		Open:        rate.Open,
+		Close:       rate.Close,
+		Volume:      rate.Volume,
 	}
 }
 
 func init() {
 	db.Migrate(&Rate{})
+	migrateRateKey()
+
+	if size, err := strconv.Atoi(env("RATE_BATCH_SIZE")); err == nil && size > 0 && size <= maxRateBatchSize {
+		rateBatchSize = size
+	}
+}
+
+// migrateRateKey adds granularity to the primary key of a rates table created before granularities existed.
+func migrateRateKey() {
+
+	var columns int64
+	db.Resolve().
+		Raw("SELECT count(*) FROM information_schema.key_column_usage WHERE table_name = 'rates' AND constraint_name = 'rates_pkey'").
+		Scan(&columns)
+
+	if columns != 2 {
+		return
+	}
+
+	if err := db.Resolve().
+		Exec("ALTER TABLE rates DROP CONSTRAINT rates_pkey, ADD PRIMARY KEY (unix_second, product_id, granularity)").
+		Error; err != nil {
+		log.Err(err).Msg("migrating rate key")
+	}
 }
 
 func (r Rate) Run(event *zerolog.Event, level zerolog.Level, msg string) {
@@ -94,6 +145,20 @@ func (r *Rate) Time() time.Time {
 	return time.Unix(r.UnixSecond, 0)
 }
 
+// Candle is the rate as the indicators read it.
+func (r *Rate) Candle() indicator.Candle {
+	return indicator.Candle{Open: r.Open, High: r.High, Low: r.Low, Close: r.Close, Volume: r.Volume}
+}
+
+// Candles is the rates as the indicators read them.
+func Candles(rates []Rate) []indicator.Candle {
+	candles := make([]indicator.Candle, len(rates))
+	for i := range rates {
+		candles[i] = rates[i].Candle()
+	}
+	return candles
+}
+
 func (r *Rate) data() []interface{} {
 	return []interface{}{r.Time().UnixMilli(), r.Open, r.High, r.Low, r.Close, r.Volume}
 }
@@ -102,6 +167,7 @@ func FindFirstRateByProductIDInTimeDescOrder(productID string, r *Rate) {
 	db.Resolve().
 		Preload("Product").
 		Where("product_id = ?", productID).
+		Where("granularity = ?", Minute).
 		Order("unix_second desc").
 		First(r)
 }
@@ -110,23 +176,26 @@ func FindFirstRateByProductIDAndLessThanTimeInTimeDescOrder(productID string, be
 	db.Resolve().
 		Preload("Product").
 		Where("product_id = ?", productID).
+		Where("granularity = ?", Minute).
 		Where("unix_second < ?", before).
 		Order("unix_second desc").
 		First(r)
 }
 
-func FindRates(productID string, alpha, omega int64) []Rate {
+func FindRates(productID string, alpha, omega int64, granularity Granularity) []Rate {
 	var rates []Rate
 
 	log.Trace().
 		Str("productID", productID).
 		Int64("alpha", alpha).
 		Int64("omega", omega).
+		Int64("granularity", int64(granularity)).
 		Msg("find rates")
 
 	db.Resolve().
 		Preload("Product").
 		Where("product_id = ?", productID).
+		Where("granularity = ?", granularity).
 		Where("unix_second BETWEEN ? AND ?", alpha, omega).
 		Order("unix_second asc").
 		Find(&rates)
@@ -142,75 +211,113 @@ func FindRates(productID string, alpha, omega int64) []Rate {
 }
 
 // GetRates is the primary method for getting rates.
-func GetRates(userID uint, productID string, alpha, omega int64) ([]Rate, error) {
+// Rates wider than a minute are aggregated from minute rates when those are mostly stored, else fetched directly.
+func GetRates(productID string, alpha, omega int64, granularity Granularity) ([]Rate, error) {
 
-	rates := FindRates(productID, alpha, omega)
-
-	from := time.Unix(alpha, 0)
-	to := time.Unix(omega, 0)
+	if granularity == Minute {
+		return getRates(productID, alpha, omega, Minute)
+	}
 
-	if len(rates) > 0 {
-		from = rates[len(rates)-1].Time().UTC()
+	if !granularity.aggregates(productID, alpha, omega) {
+		return getRates(productID, alpha, omega, granularity)
 	}
 
-	out, err := GetHistoricRates(userID, productID, from, to)
+	rates, err := getRates(productID, alpha, omega, Minute)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, rate := range out {
-		newRate := NewRate(productID, rate)
-		tx := db.Resolve().Create(&newRate)
-		if tx.Error != nil {
-			db.Resolve().Save(&newRate)
-		}
+	return aggregate(rates, granularity), nil
+}
+
+// getRates backfills the rates of a granularity missing between alpha and omega, then returns every stored rate.
+func getRates(productID string, alpha, omega int64, granularity Granularity) ([]Rate, error) {
+
+	if _, err := BackfillRates(productID, alpha, omega, granularity); err != nil {
+		return nil, err
 	}
 
-	return FindRates(productID, alpha, omega), nil
+	return FindRates(productID, alpha, omega, granularity), nil
 }
 
-func GetHistoricRates(userID uint, productID string, alpha, omega time.Time) ([]cb.HistoricRate, error) {
+// missingRates counts the candles of a granularity missing from the rate store between alpha and omega.
+func missingRates(productID string, alpha, omega int64, granularity Granularity) int64 {
+	var missing int64
+	for _, gap := range findGaps(productID, alpha, omega, granularity) {
+		missing += gap.candles(granularity)
+	}
+	return missing
+}
 
-	var rates []cb.HistoricRate
+// saveExchangeRates upserts rates fetched from the exchange in batches. A feed rate of the same candle is kept
+// as the rate sessions acted on, and is marked reconciled with its drift from the exchange close.
+func saveExchangeRates(rates []Rate) error {
 
-	params := rateParams(alpha, omega)
+	if len(rates) == 0 {
+		return nil
+	}
 
-	u := FindUserByID(userID)
+	kept := func(column string) clause.Expr {
+		return gorm.Expr("CASE WHEN rates.feed THEN rates." + column + " ELSE excluded." + column + " END")
+	}
 
-	for _, p := range params {
-		out, err := u.Client().GetHistoricRates(productID, p)
-		if err != nil {
-			log.Err(err).Send()
-			return nil, err
-		}
-		rates = append(rates, out...)
+	if err := db.Resolve().
+		Omit(clause.Associations).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "unix_second"}, {Name: "product_id"}, {Name: "granularity"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"low":        kept("low"),
+				"high":       kept("high"),
+				"open":       kept("open"),
+				"close":      kept("close"),
+				"volume":     kept("volume"),
+				"updated_at": gorm.Expr("excluded.updated_at"),
+				"deleted_at": nil,
+				"reconciled": gorm.Expr("rates.feed"),
+				"drift":      gorm.Expr("CASE WHEN rates.feed AND NOT rates.reconciled THEN excluded.close - rates.close ELSE rates.drift END"),
+			}),
+		}).
+		CreateInBatches(&rates, rateBatchSize).
+		Error; err != nil {
+		log.Err(err).Str("productID", rates[0].ProductID).Int("rates", len(rates)).Msg("saving exchange rates")
+		return err
 	}
 
-	return rates, nil
+	return nil
 }
 
-func rateParams(alpha, omega time.Time) []cb.GetHistoricRatesParams {
+// saveFeedRate stores a rate built from the ticker feed, leaving any rate already stored for the minute untouched.
+func saveFeedRate(rate Rate) {
+	rate.Feed = true
+	if err := db.Resolve().Clauses(clause.OnConflict{DoNothing: true}).Create(&rate).Error; err != nil {
+		rate.log().Err(err).Msg("saving feed rate")
+	}
+}
+
+func GetHistoricRates(productID string, alpha, omega time.Time, granularity Granularity) ([]cb.HistoricRate, error) {
+
+	var rates []cb.HistoricRate
 
-	start := alpha
-	end := alpha.Add(time.Hour * 4)
+	gap := Gap{granularity.bucket(alpha.Unix()), granularity.bucket(omega.Unix())}
 
-	var results []cb.GetHistoricRatesParams
+	client := marketData()
 
-	for i := 0.0; i < 24; i += 4 {
-		results = append(results, cb.GetHistoricRatesParams{start, end, 60})
-		start = end
-		end = start.Add(time.Hour * 4)
-		if start.After(omega) {
-			break
+	for _, p := range pages([]Gap{gap}, granularity) {
+		out, err := getHistoricRates(client, productID, p)
+		if err != nil {
+			log.Err(err).Send()
+			return nil, err
 		}
+		rates = append(rates, out...)
 	}
-	return results
+
+	return rates, nil
 }
 
 func rate(productID string) (Rate, error) {
 
 	var wsDialer ws.Dialer
-	wsConn, _, err := wsDialer.Dial("wss://ws-feed.pro.coinbase.com", nil)
+	wsConn, _, err := wsDialer.Dial(environment.Feed, nil)
 	if err != nil {
 		log.Error().Err(err).Msg("opening ws")
 		return Rate{}, err
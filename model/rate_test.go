@@ -1,6 +1,7 @@
 package model
 
 import (
+	cb "github.com/preichenberger/go-coinbasepro/v2"
 	"nuchal-api/util"
 	"testing"
 	"time"
@@ -15,7 +16,7 @@ var (
 
 func TestGetRates(t *testing.T) {
 
-	rates, err := GetRates(userID, productID, alpha, omega)
+	rates, err := GetRates(productID, alpha, omega, Minute)
 	if err != nil {
 		t.Fail()
 	}
@@ -45,3 +46,20 @@ func TestFindRates(t *testing.T) {
 	//
 	//util.PrettyPrint(rates)
 }
+
+func TestSaveFeedRate(t *testing.T) {
+
+	at := time.Unix(60, 0)
+
+	saveFeedRate(NewRate(productID, cb.HistoricRate{Time: at, Low: 1, High: 3, Open: 2, Close: 2}))
+	if err := saveExchangeRates([]Rate{NewRate(productID, cb.HistoricRate{Time: at, Low: 1, High: 3, Open: 2, Close: 2.5})}); err != nil {
+		t.Fail()
+	}
+
+	rates := FindRates(productID, at.Unix(), at.Unix(), Minute)
+	if len(rates) != 1 || !rates[0].Feed || !rates[0].Reconciled || rates[0].Drift != 0.5 {
+		t.Fail()
+	}
+
+	util.PrettyPrint(rates)
+}
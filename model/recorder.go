@@ -0,0 +1,87 @@
+package model
+
+import (
+	"github.com/rs/zerolog/log"
+	"nuchal-api/db"
+	"strconv"
+	"time"
+)
+
+const (
+	// recordBatch is the most ticks written per statement.
+	recordBatch = 500
+
+	// recordInterval is the longest a received tick waits before it is written.
+	recordInterval = time.Second
+)
+
+// recorder batches every tick the hubs receive into the ticks table, when RECORD_TICKS is true.
+var recorder chan Tick
+
+func init() {
+	db.Migrate(&Tick{})
+
+	if enabled, err := strconv.ParseBool(env("RECORD_TICKS")); err == nil && enabled {
+		recorder = make(chan Tick, 8*recordBatch)
+		go record(recorder)
+	}
+}
+
+// recordTick queues a tick for recording without blocking the Hub, dropping it when the recorder falls behind.
+func recordTick(tick Tick) {
+	if recorder == nil {
+		return
+	}
+	select {
+	case recorder <- tick:
+	default:
+		log.Warn().Str("productID", tick.ProductID).Int64("sequence", tick.Sequence).Msg("dropped tick recording")
+	}
+}
+
+func record(ticks chan Tick) {
+
+	flush := time.NewTicker(recordInterval)
+	defer flush.Stop()
+
+	var batch []Tick
+
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := db.Resolve().Create(&batch).Error; err != nil {
+			log.Err(err).Int("ticks", len(batch)).Msg("recording ticks")
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case tick := <-ticks:
+			if batch = append(batch, tick); len(batch) == recordBatch {
+				write()
+			}
+		case <-flush.C:
+			write()
+		}
+	}
+}
+
+// FindTicks reads the recorded ticks of a product between alpha and omega in the order they were received.
+func FindTicks(productID string, alpha, omega time.Time) []Tick {
+	var ticks []Tick
+	db.Resolve().
+		Where("product_id = ?", productID).
+		Where(`"time" BETWEEN ? AND ?`, alpha, omega).
+		Order(`"time" asc, id asc`).
+		Find(&ticks)
+	return ticks
+}
+
+// FindSellSessionTicks reads the recorded ticks of a sell session's product from its start to its last update.
+func FindSellSessionTicks(ID uint) []Tick {
+	var s SellSession
+	db.Resolve().First(&s, ID)
+	return FindTicks(s.ProductID, s.CreatedAt, s.UpdatedAt)
+}
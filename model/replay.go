@@ -0,0 +1,131 @@
+package model
+
+import (
+	"errors"
+	"nuchal-api/db"
+	"time"
+)
+
+var errReplayDone = errors.New("replay finished")
+
+// NewReplayPipe returns a Pipe reading the recorded ticks of a product between alpha and omega, and the minute
+// rates they build, at speed times the pace they were received.
+func NewReplayPipe(productID string, alpha, omega time.Time, speed float64) (*Pipe, error) {
+
+	if speed <= 0 {
+		return nil, errors.New("replay speed must be positive")
+	}
+
+	ticks := FindTicks(productID, alpha, omega)
+	if len(ticks) == 0 {
+		return nil, errors.New("no recorded ticks for " + productID)
+	}
+
+	return newReplayPipe(productID, ticks, speed), nil
+}
+
+// ReplaySellSession sends the recorded ticks of a sell session, and the candles they build, at speed times the pace
+// they were received, as Stream sends live ones, until the ticks run out, done is closed, or send fails.
+func ReplaySellSession(ID uint, speed float64, send func(StreamMessage) error, done <-chan struct{}) error {
+
+	var s SellSession
+	db.Resolve().First(&s, ID)
+	if s.ID == 0 {
+		return errors.New("sell session not found")
+	}
+
+	pipe, err := NewReplayPipe(s.ProductID, s.CreatedAt, s.UpdatedAt, speed)
+	if err != nil {
+		return err
+	}
+
+	return replay(pipe, send, done)
+}
+
+// replay streams a replay Pipe, for which a lost Subscription means its ticks ran out.
+func replay(pipe *Pipe, send func(StreamMessage) error, done <-chan struct{}) error {
+	if err := stream(pipe.sub, send, done); err != errPipeLost {
+		return err
+	}
+	return nil
+}
+
+func newReplayPipe(productID string, ticks []Tick, speed float64) *Pipe {
+
+	// a Hub of its own builds the candles, and lets the Pipe close its Subscription as usual
+	h := &Hub{
+		feed:    "replay",
+		subs:    map[string]map[*Subscription]bool{},
+		candles: map[string]*candle{},
+		closed:  map[string]time.Time{},
+		books:   map[string]*Book{},
+	}
+
+	s := &Subscription{
+		ProductID: productID,
+		Ticks:     make(chan Tick, 64),
+		Rates:     make(chan Rate, 4),
+		Lost:      make(chan struct{}),
+		hub:       h,
+	}
+
+	h.subs[productID] = map[*Subscription]bool{s: true}
+
+	go h.replay(s, ticks, speed)
+
+	return &Pipe{sub: s, feed: h.feed, productID: productID, replaying: true}
+}
+
+// replay dispatches the ticks on a clock of their own recorded times, closing candles on minute boundaries as
+// the live clock does, so the Subscription sees what a live one saw. Lost is closed once the ticks run out.
+func (h *Hub) replay(s *Subscription, ticks []Tick, speed float64) {
+
+	defer close(s.Lost)
+
+	at := ticks[0].Time
+
+	wait := func(until time.Time) {
+		if d := until.Sub(at); d > 0 {
+			time.Sleep(time.Duration(float64(d) / speed))
+			at = until
+		}
+	}
+
+	// closeCandle publishes the open candle if it ended by the given time, reporting false once the Subscription is closed
+	closeCandle := func(by time.Time) bool {
+		h.mu.Lock()
+		c := h.candles[s.ProductID]
+		h.mu.Unlock()
+
+		if c != nil && !by.Before(c.start.Add(time.Minute)) {
+			wait(c.start.Add(time.Minute))
+			h.mu.Lock()
+			h.closed[s.ProductID] = c.start
+			delete(h.candles, s.ProductID)
+			h.publish(c.rate(s.ProductID))
+			h.mu.Unlock()
+		}
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.subs[s.ProductID][s]
+	}
+
+	for _, tick := range ticks {
+
+		if !closeCandle(tick.Time) {
+			return
+		}
+
+		wait(tick.Time)
+
+		h.mu.Lock()
+		if rate, closed := h.build(tick); closed {
+			h.publish(rate)
+		}
+		offerTick(s.Ticks, tick)
+		h.mu.Unlock()
+	}
+
+	closeCandle(at.Truncate(time.Minute).Add(time.Minute))
+}
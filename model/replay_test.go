@@ -0,0 +1,78 @@
+package model
+
+import (
+	"nuchal-api/util"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+
+	minute := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	var ticks []Tick
+	for i, price := range []float64{1, 3, 0.5, 2, 5} {
+		ticks = append(ticks, Tick{ProductID: productID, Price: price, LastSize: 1, Time: minute.Add(time.Duration(i) * 20 * time.Second)})
+	}
+
+	pipe := newReplayPipe(productID, ticks, 600)
+
+	if price, err := pipe.getPrice(); err != nil || price != 1 {
+		t.Fail()
+	}
+
+	rate, err := pipe.getRate()
+	if err != nil || rate.UnixSecond != minute.Unix() || rate.High != 3 || rate.Low != 0.5 || rate.Close != 0.5 {
+		t.Fail()
+	}
+
+	if rate, err = pipe.getRate(); err != nil || rate.Open != 2 || rate.Close != 5 {
+		t.Fail()
+	}
+
+	if price, err := pipe.getPrice(); err != nil || price != 5 {
+		t.Fail()
+	}
+
+	if _, err = pipe.getPrice(); err == nil {
+		t.Fail()
+	}
+
+	if err = pipe.Reopen(); err != errReplayDone {
+		t.Fail()
+	}
+
+	util.PrettyPrint(rate)
+}
+
+func TestReplayStream(t *testing.T) {
+
+	minute := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	var ticks []Tick
+	for i, price := range []float64{1, 3, 0.5, 2, 5} {
+		ticks = append(ticks, Tick{ProductID: productID, Price: price, LastSize: 1, Time: minute.Add(time.Duration(i) * 20 * time.Second)})
+	}
+
+	var messages []StreamMessage
+	send := func(msg StreamMessage) error {
+		messages = append(messages, msg)
+		return nil
+	}
+
+	if err := replay(newReplayPipe(productID, ticks, 600), send, make(chan struct{})); err != nil {
+		t.Fail()
+	}
+
+	var candles int
+	for _, msg := range messages {
+		if msg.Type == candleMessage {
+			candles++
+		}
+	}
+	if candles != 2 {
+		t.Fail()
+	}
+
+	util.PrettyPrint(messages)
+}
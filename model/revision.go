@@ -0,0 +1,256 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"nuchal-api/db"
+	"sort"
+	"strings"
+)
+
+// PatternRevision is an immutable version of a pattern, created each time it is saved.
+type PatternRevision struct {
+	UintModel
+	PatternID uint   `json:"pattern_id" gorm:"uniqueIndex:idx_pattern_revision"`
+	Version   int    `json:"version" gorm:"uniqueIndex:idx_pattern_revision"`
+	Data      string `json:"-" gorm:"type:jsonb"`
+
+	// Pattern is the pattern as it was saved, decoded from Data.
+	Pattern Pattern `json:"pattern" gorm:"-"`
+}
+
+// Change is a field that differs between two revisions of a pattern.
+type Change struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+func init() {
+	db.Migrate(&PatternRevision{})
+}
+
+// revise records the saved pattern as its next revision within the transaction, locking the pattern so that
+// concurrent saves number their revisions in turn.
+func (p *Pattern) revise(tx *gorm.DB) error {
+
+	var locked Pattern
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&locked, p.ID).Error; err != nil {
+		return err
+	}
+
+	var last int
+	if err := tx.
+		Model(&PatternRevision{}).
+		Where("pattern_id = ?", p.ID).
+		Select("coalesce(max(version), 0)").
+		Scan(&last).Error; err != nil {
+		return err
+	}
+
+	p.Revision = last + 1
+	if err := tx.Model(p).UpdateColumn("revision", p.Revision).Error; err != nil {
+		return err
+	}
+
+	snapshot := *p
+	snapshot.Product = Product{}
+	snapshot.User = User{}
+	snapshot.Projection = Projection{}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(&PatternRevision{PatternID: p.ID, Version: p.Revision, Data: string(data)}).Error
+}
+
+func (r *PatternRevision) decode() error {
+	return json.Unmarshal([]byte(r.Data), &r.Pattern)
+}
+
+// GetPatternRevisions lists the revisions of a pattern, newest first.
+func GetPatternRevisions(patternID uint) ([]PatternRevision, error) {
+
+	var revisions []PatternRevision
+
+	db.Resolve().
+		Where("pattern_id = ?", patternID).
+		Order("version desc").
+		Find(&revisions)
+
+	for i := range revisions {
+		if err := revisions[i].decode(); err != nil {
+			return nil, err
+		}
+	}
+
+	return revisions, nil
+}
+
+// FindPatternRevision finds a revision of a pattern, with its current product and user.
+func FindPatternRevision(patternID uint, version int) (Pattern, error) {
+
+	var revision PatternRevision
+
+	db.Resolve().
+		Where("pattern_id = ?", patternID).
+		Where("version = ?", version).
+		Find(&revision)
+
+	if revision.ID == 0 {
+		return Pattern{}, fmt.Errorf("pattern %d has no revision %d", patternID, version)
+	}
+
+	if err := revision.decode(); err != nil {
+		return Pattern{}, err
+	}
+
+	pattern := revision.Pattern
+
+	var err error
+	if pattern.Product, err = FindProductByID(pattern.ProductID); err != nil {
+		return Pattern{}, err
+	}
+	pattern.User = FindUserByID(pattern.UserID)
+
+	return pattern, nil
+}
+
+// FindPatternAt finds a revision of a pattern, or the pattern as it is now when the revision is zero.
+func FindPatternAt(patternID uint, version int) (Pattern, error) {
+	if version == 0 {
+		return FindPatternByID(patternID), nil
+	}
+	return FindPatternRevision(patternID, version)
+}
+
+// RestorePatternRevision saves a revision of a pattern over the pattern, as its next revision.
+func RestorePatternRevision(patternID uint, version int) (Pattern, error) {
+
+	pattern, err := FindPatternRevision(patternID, version)
+	if err != nil {
+		return Pattern{}, err
+	}
+
+	if err = pattern.Validate(); err != nil {
+		return Pattern{}, err
+	}
+
+	if err = pattern.Save(); err != nil {
+		return Pattern{}, err
+	}
+
+	return FindPatternByID(patternID), nil
+}
+
+// DiffPatternRevisions lists the fields that changed from one revision of a pattern to another.
+func DiffPatternRevisions(patternID uint, from, to int) ([]Change, error) {
+
+	a, err := FindPatternRevision(patternID, from)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := FindPatternRevision(patternID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return diff(a, b)
+}
+
+// diff compares the fields of two patterns that define how they trade.
+func diff(a, b Pattern) ([]Change, error) {
+
+	x, err := a.fields()
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := b.fields()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for field := range x {
+		if x[field] != y[field] {
+			changes = append(changes, Change{field, x[field], y[field]})
+		}
+	}
+	for field := range y {
+		if _, ok := x[field]; !ok {
+			changes = append(changes, Change{field, "", y[field]})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes, nil
+}
+
+// revisionIgnored are the fields of a pattern that say nothing about how it trades.
+var revisionIgnored = map[string]bool{
+	"id":         true,
+	"created_at": true,
+	"updated_at": true,
+	"deleted_at": true,
+	"revision":   true,
+	"product":    true,
+	"projection": true,
+	"filters":    true,
+}
+
+// fields flattens the pattern to its json field names, nested fields joined by dots and each filter written out.
+func (p Pattern) fields() (map[string]string, error) {
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	flatten("", m, fields)
+
+	var filters []string
+	for _, f := range p.Filters {
+		filters = append(filters, f.String())
+	}
+	fields["filters"] = strings.Join(filters, ", ")
+
+	return fields, nil
+}
+
+func flatten(prefix string, m map[string]interface{}, fields map[string]string) {
+	for key, value := range m {
+		if prefix == "" && revisionIgnored[key] {
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			flatten(prefix+key+".", nested, fields)
+			continue
+		}
+		fields[prefix+key] = fmt.Sprint(value)
+	}
+}
+
+// ensureRevision revises a pattern saved before revisions were kept, so a session has a revision to record.
+func (p *Pattern) ensureRevision() error {
+	if p.Revision == 0 && p.ID > 0 {
+		log.Info().Uint("patternID", p.ID).Msg("recording a first revision")
+		return db.Resolve().Transaction(p.revise)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"nuchal-api/util"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+
+	a := Pattern{ProductID: productID, Target: 0.02, Tolerance: 0.01, Size: 1, Revision: 1}
+	a.Filters = []Filter{{Left: Operand{Indicator: rsiIndicator, Period: 14}, Op: below, Right: Operand{Value: 30}}}
+
+	b := a
+	b.Revision = 2
+	b.Target = 0.03
+	b.Strategy = Strategy{Exit: TrailingExit, Trail: 0.05}
+	b.Filters = nil
+
+	changes, err := diff(a, b)
+	if err != nil {
+		t.FailNow()
+	}
+
+	util.PrettyPrint(changes)
+
+	expected := []Change{
+		{"filters", "rsi(14) < 30", ""},
+		{"strategy.exit", "", "trailing"},
+		{"strategy.trail", "0", "0.05"},
+		{"target", "0.02", "0.03"},
+	}
+
+	if len(changes) != len(expected) {
+		t.FailNow()
+	}
+
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fail()
+		}
+	}
+
+	if changes, _ = diff(a, a); len(changes) != 0 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,608 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Rule is a compiled condition on the current and prior rates of a product and their indicators.
+// e.g. close < bb_lower(20,2) and rsi(14) < 25, or close > close[1] * 1.01
+//
+// A name reads a field of the latest rate (open, high, low, close, volume), a call reads an indicator as of it with
+// constant arguments (sma, ema, rsi, atr, macd, macd_signal, macd_histogram, bb_upper, bb_middle, bb_lower, and vwap
+// and obv over a period), where sma, ema, rsi, macd and the bands may take a field as their first argument,
+// and [n] reads either as of n rates before the latest.
+type Rule struct {
+	text string
+	root node
+}
+
+const (
+	entryRule = "entry"
+	exitRule  = "exit"
+)
+
+// RuleError is a rule that does not compile, at a 1-based position of its text.
+type RuleError struct {
+	Rule     string `json:"rule"`
+	Position int    `json:"position"`
+	Message  string `json:"error"`
+}
+
+func (e *RuleError) Error() string {
+	if e.Rule != "" {
+		return fmt.Sprintf("%s rule, position %d: %s", e.Rule, e.Position, e.Message)
+	}
+	return fmt.Sprintf("position %d: %s", e.Position, e.Message)
+}
+
+// CompileRule parses the text of a rule, which must be a condition rather than a number.
+func CompileRule(text string) (*Rule, error) {
+
+	tokens, err := lex(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != endToken {
+		return nil, t.errorf("unexpected %q", t.text)
+	}
+
+	if !root.boolean() {
+		return nil, &RuleError{Position: 1, Message: "rule is a number, not a condition"}
+	}
+
+	return &Rule{text, root}, nil
+}
+
+func (r *Rule) String() string {
+	return r.text
+}
+
+// compileRule compiles a rule of a pattern, naming it in any error.
+func compileRule(name, text string) (*Rule, error) {
+	rule, err := CompileRule(text)
+	if err != nil {
+		if ruleErr, ok := err.(*RuleError); ok {
+			ruleErr.Rule = name
+		}
+		return nil, err
+	}
+	return rule, nil
+}
+
+// entryRule is the compiled entry rule of the pattern, nil when it has none.
+func (p *Pattern) entryRule() *Rule {
+	return p.rule(entryRule, p.Entry)
+}
+
+// exitRule is the compiled exit rule of the pattern, nil when it has none.
+func (p *Pattern) exitRule() *Rule {
+	return p.rule(exitRule, p.Exit)
+}
+
+func (p *Pattern) rule(name, text string) *Rule {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	rule, err := compileRule(name, text)
+	if err != nil {
+		p.log().Err(err).Send()
+		return nil
+	}
+	return rule
+}
+
+// Holds reports whether the rule holds as of the latest rate, which it does not until its indicators are ready.
+func (r *Rule) Holds(rates []Rate) bool {
+	v, ok := r.root.eval(rates)
+	return ok && v != 0
+}
+
+// lookback is how many of the most recent rates the rule reads.
+func (r *Rule) lookback() int {
+	return r.root.lookback()
+}
+
+type tokenKind int
+
+const (
+	endToken tokenKind = iota
+	numberToken
+	nameToken
+	symbolToken
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+	num  float64
+}
+
+func (t token) errorf(format string, a ...interface{}) *RuleError {
+	return &RuleError{Position: t.pos, Message: fmt.Sprintf(format, a...)}
+}
+
+// symbols are the operators and punctuation of the language, longest first.
+var symbols = []string{"<=", ">=", "==", "!=", "<", ">", "+", "-", "*", "/", "(", ")", "[", "]", ","}
+
+func lex(text string) ([]token, error) {
+
+	var tokens []token
+
+	for i := 0; i < len(text); {
+
+		c := rune(text[i])
+		pos := i + 1
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(text) && (unicode.IsDigit(rune(text[j])) || text[j] == '.') {
+				j++
+			}
+			num, err := strconv.ParseFloat(text[i:j], 64)
+			if err != nil {
+				return nil, &RuleError{Position: pos, Message: fmt.Sprintf("bad number %q", text[i:j])}
+			}
+			tokens = append(tokens, token{numberToken, text[i:j], pos, num})
+			i = j
+
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(text) && (unicode.IsLetter(rune(text[j])) || unicode.IsDigit(rune(text[j])) || text[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, token{nameToken, strings.ToLower(text[i:j]), pos, 0})
+			i = j
+
+		default:
+			var symbol string
+			for _, s := range symbols {
+				if strings.HasPrefix(text[i:], s) {
+					symbol = s
+					break
+				}
+			}
+			if symbol == "" {
+				return nil, &RuleError{Position: pos, Message: fmt.Sprintf("unexpected %q", text[i:i+1])}
+			}
+			tokens = append(tokens, token{symbolToken, symbol, pos, 0})
+			i += len(symbol)
+		}
+	}
+
+	return append(tokens, token{endToken, "end of rule", len(text) + 1, 0}), nil
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != endToken {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of the given symbols or keywords.
+func (p *parser) accept(texts ...string) (token, bool) {
+	t := p.peek()
+	if t.kind == symbolToken || t.kind == nameToken {
+		for _, text := range texts {
+			if t.text == text {
+				return p.next(), true
+			}
+		}
+	}
+	return t, false
+}
+
+func (p *parser) expect(text string) error {
+	if t, ok := p.accept(text); !ok {
+		return t.errorf("expected %q, found %q", text, t.text)
+	}
+	return nil
+}
+
+func (p *parser) or() (node, error) {
+	x, err := p.and()
+	for err == nil {
+		t, ok := p.accept("or")
+		if !ok {
+			break
+		}
+		var y node
+		if y, err = p.and(); err == nil {
+			x, err = logical(t, x, y)
+		}
+	}
+	return x, err
+}
+
+func (p *parser) and() (node, error) {
+	x, err := p.not()
+	for err == nil {
+		t, ok := p.accept("and")
+		if !ok {
+			break
+		}
+		var y node
+		if y, err = p.not(); err == nil {
+			x, err = logical(t, x, y)
+		}
+	}
+	return x, err
+}
+
+func (p *parser) not() (node, error) {
+	if t, ok := p.accept("not"); ok {
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		if !x.boolean() {
+			return nil, t.errorf("not needs a condition")
+		}
+		return &unaryNode{t.text, x}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	x, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.accept("<", "<=", ">", ">=", "==", "!="); ok {
+		y, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if x.boolean() || y.boolean() {
+			return nil, t.errorf("%s compares numbers, not conditions", t.text)
+		}
+		return &binaryNode{t.text, x, y}, nil
+	}
+	return x, nil
+}
+
+func (p *parser) sum() (node, error) {
+	x, err := p.term()
+	for err == nil {
+		t, ok := p.accept("+", "-")
+		if !ok {
+			break
+		}
+		var y node
+		if y, err = p.term(); err == nil {
+			x, err = arithmetic(t, x, y)
+		}
+	}
+	return x, err
+}
+
+func (p *parser) term() (node, error) {
+	x, err := p.unary()
+	for err == nil {
+		t, ok := p.accept("*", "/")
+		if !ok {
+			break
+		}
+		var y node
+		if y, err = p.unary(); err == nil {
+			x, err = arithmetic(t, x, y)
+		}
+	}
+	return x, err
+}
+
+func (p *parser) unary() (node, error) {
+	if t, ok := p.accept("-"); ok {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if x.boolean() {
+			return nil, t.errorf("cannot negate a condition")
+		}
+		return &unaryNode{t.text, x}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+
+	t := p.next()
+
+	switch {
+	case t.kind == numberToken:
+		return &numberNode{t.num}, nil
+
+	case t.kind == nameToken && (t.text == "true" || t.text == "false"):
+		return &conditionNode{t.text == "true"}, nil
+
+	case t.kind == nameToken:
+		o, err := p.operand(t)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := p.offset()
+		if err != nil {
+			return nil, err
+		}
+		return &operandNode{o, offset}, nil
+
+	case t.kind == symbolToken && t.text == "(":
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	}
+
+	return nil, t.errorf("unexpected %q", t.text)
+}
+
+// operand reads a field, or an indicator and its arguments, of the name just consumed.
+func (p *parser) operand(name token) (Operand, error) {
+
+	o := Operand{Indicator: Indicator(name.text)}
+
+	switch o.Indicator {
+	case openIndicator, highIndicator, lowIndicator, closeIndicator, volumeIndicator:
+		return o, nil
+	case smaIndicator, emaIndicator, rsiIndicator, atrIndicator, macdIndicator, signalIndicator, histogramIndicator,
+		upperIndicator, middleIndicator, lowerIndicator, vwapIndicator, obvIndicator:
+	default:
+		return o, name.errorf("unknown name %q", name.text)
+	}
+
+	if err := p.expect("("); err != nil {
+		return o, err
+	}
+
+	if !o.readsCandles() {
+		if t, ok := p.accept(openIndicator, highIndicator, lowIndicator, closeIndicator, volumeIndicator); ok {
+			o.Source = Indicator(t.text)
+			if _, ok = p.accept(","); !ok {
+				if err := p.expect(")"); err != nil {
+					return o, err
+				}
+				return o, o.arguments(name, nil)
+			}
+		}
+	}
+
+	var args []token
+	if _, ok := p.accept(")"); !ok {
+		for {
+			t := p.next()
+			if t.kind != numberToken {
+				return o, t.errorf("expected a number, found %q", t.text)
+			}
+			args = append(args, t)
+			if _, ok = p.accept(","); !ok {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return o, err
+		}
+	}
+
+	return o, o.arguments(name, args)
+}
+
+// arguments sets the periods of an indicator from the arguments of its call.
+func (o *Operand) arguments(name token, args []token) error {
+
+	var periods []*int
+	var most int
+
+	switch o.Indicator {
+	case smaIndicator, emaIndicator, rsiIndicator, atrIndicator, vwapIndicator, obvIndicator:
+		periods, most = []*int{&o.Period}, 1
+	case macdIndicator, signalIndicator, histogramIndicator:
+		o.Period = 12
+		periods, most = []*int{&o.Period, &o.Slow, &o.Signal}, 3
+	case upperIndicator, middleIndicator, lowerIndicator:
+		periods, most = []*int{&o.Period}, 2
+	}
+
+	if len(args) > most {
+		return args[most].errorf("%s takes at most %d arguments", o.Indicator, most)
+	}
+
+	for i, arg := range args {
+		if i < len(periods) {
+			if arg.num != math.Trunc(arg.num) {
+				return arg.errorf("%s needs a whole period", o.Indicator)
+			}
+			*periods[i] = int(arg.num)
+		} else {
+			o.Deviations = arg.num
+		}
+	}
+
+	if err := o.validate(); err != nil {
+		return name.errorf("%s", err)
+	}
+
+	return nil
+}
+
+// offset reads an optional [n], the number of rates before the latest.
+func (p *parser) offset() (int, error) {
+	if _, ok := p.accept("["); !ok {
+		return 0, nil
+	}
+	t := p.next()
+	if t.kind != numberToken || t.num != math.Trunc(t.num) {
+		return 0, t.errorf("expected a whole number of rates, found %q", t.text)
+	}
+	return int(t.num), p.expect("]")
+}
+
+func logical(t token, x, y node) (node, error) {
+	if !x.boolean() || !y.boolean() {
+		return nil, t.errorf("%s joins conditions, not numbers", t.text)
+	}
+	return &binaryNode{t.text, x, y}, nil
+}
+
+func arithmetic(t token, x, y node) (node, error) {
+	if x.boolean() || y.boolean() {
+		return nil, t.errorf("%s needs numbers, not conditions", t.text)
+	}
+	return &binaryNode{t.text, x, y}, nil
+}
+
+// node evaluates part of a rule as of the latest rate, false until its indicators are ready.
+// Conditions evaluate to 1 or 0.
+type node interface {
+	eval(rates []Rate) (float64, bool)
+	boolean() bool
+	lookback() int
+}
+
+type numberNode struct {
+	v float64
+}
+
+func (n *numberNode) eval([]Rate) (float64, bool) { return n.v, true }
+func (n *numberNode) boolean() bool               { return false }
+func (n *numberNode) lookback() int               { return 0 }
+
+type conditionNode struct {
+	v bool
+}
+
+func (n *conditionNode) eval([]Rate) (float64, bool) { return truth(n.v), true }
+func (n *conditionNode) boolean() bool               { return true }
+func (n *conditionNode) lookback() int               { return 0 }
+
+type operandNode struct {
+	o      Operand
+	offset int
+}
+
+func (n *operandNode) eval(rates []Rate) (float64, bool) {
+	if len(rates) <= n.offset {
+		return 0, false
+	}
+	return n.o.value(rates[:len(rates)-n.offset])
+}
+
+func (n *operandNode) boolean() bool { return false }
+func (n *operandNode) lookback() int { return n.o.lookback() + n.offset }
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+func (n *unaryNode) eval(rates []Rate) (float64, bool) {
+	x, ok := n.x.eval(rates)
+	if n.op == "not" {
+		return truth(x == 0), ok
+	}
+	return -x, ok
+}
+
+func (n *unaryNode) boolean() bool { return n.op == "not" }
+func (n *unaryNode) lookback() int { return n.x.lookback() }
+
+type binaryNode struct {
+	op   string
+	x, y node
+}
+
+func (n *binaryNode) eval(rates []Rate) (float64, bool) {
+
+	x, ok := n.x.eval(rates)
+	if !ok {
+		return 0, false
+	}
+
+	// short circuit conditions that are already decided
+	if n.op == "and" && x == 0 {
+		return 0, true
+	}
+	if n.op == "or" && x != 0 {
+		return 1, true
+	}
+
+	y, ok := n.y.eval(rates)
+	if !ok {
+		return 0, false
+	}
+
+	var v float64
+	switch n.op {
+	case "and", "or":
+		v = truth(y != 0)
+	case "<":
+		v = truth(x < y)
+	case "<=":
+		v = truth(x <= y)
+	case ">":
+		v = truth(x > y)
+	case ">=":
+		v = truth(x >= y)
+	case "==":
+		v = truth(x == y)
+	case "!=":
+		v = truth(x != y)
+	case "+":
+		v = x + y
+	case "-":
+		v = x - y
+	case "*":
+		v = x * y
+	case "/":
+		v = x / y
+	}
+
+	return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func (n *binaryNode) boolean() bool {
+	switch n.op {
+	case "+", "-", "*", "/":
+		return false
+	}
+	return true
+}
+
+func (n *binaryNode) lookback() int {
+	return int(math.Max(float64(n.x.lookback()), float64(n.y.lookback())))
+}
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"nuchal-api/util"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+
+	var rates []Rate
+	for i := 0; i < 30; i++ {
+		price := float64(100 - i)
+		rates = append(rates, Rate{Open: price + 1, High: price + 1.5, Low: price - 0.5, Close: price, Volume: 10})
+	}
+
+	cases := []struct {
+		text  string
+		holds bool
+	}{
+		{"close < bb_lower(20,2) or rsi(14) < 25", true},
+		{"close < close[1] and not (volume > sma(volume, 20))", true},
+		{"close > ema(10) * 0.9 and close < 71", false},
+		{"(high - low) / close * 100 >= 2.8 and atr(14) > 1.99", true},
+		{"macd(3,6,2) < 0 and macd_signal(close,3,6,2) < 0", true},
+		{"macd() < 0", false},
+		{"rsi(14)[20] < 50", false},
+	}
+
+	for _, c := range cases {
+		rule, err := CompileRule(c.text)
+		if err != nil || rule.Holds(rates) != c.holds {
+			util.PrettyPrint(c.text)
+			t.Fail()
+		}
+	}
+
+	errors := []struct {
+		text     string
+		position int
+	}{
+		{"close < ", 9},
+		{"close + 1", 1},
+		{"rsi(14) < 30 and", 17},
+		{"sma(0) > close", 1},
+		{"close > ema(200", 16},
+		{"close >> 1", 8},
+		{"close < vwap(20) and stoch(14) < 20", 22},
+		{"close < vwap", 13},
+		{"close ~ 1", 7},
+		{"close[1.5] < 2", 7},
+	}
+
+	for _, c := range errors {
+		_, err := CompileRule(c.text)
+		if ruleErr, ok := err.(*RuleError); !ok || ruleErr.Position != c.position {
+			util.PrettyPrint(c.text)
+			util.PrettyPrint(err)
+			t.Fail()
+		}
+	}
+
+	p := &Pattern{Size: 1, Target: 0.05, Entry: "rsi(14) < 30", Exit: "close > ema(50)"}
+	if p.validate(testProduct(), testUser()) != nil || p.lookback() != 150 {
+		t.Fail()
+	}
+
+	p.Exit = "close >"
+	if err, ok := p.validate(testProduct(), testUser()).(*ValidationError); !ok || err.Fields[0].Field != exitRule || err.Fields[0].Position != 8 {
+		t.Fail()
+	}
+}
+
+func TestVolumeRules(t *testing.T) {
+
+	var rates []Rate
+	for i, price := range []float64{10, 11, 12, 11, 13} {
+		rates = append(rates, Rate{Open: price, High: price, Low: price, Close: price, Volume: float64(i + 1)})
+	}
+
+	rule, err := CompileRule("close > vwap(3) and obv(3) == 4 and obv(2)[1] == -1")
+	if err != nil || !rule.Holds(rates) || rule.lookback() != 4 {
+		util.PrettyPrint(err)
+		t.Fail()
+	}
+
+	// a single candle's vwap is its own typical price
+	if rule, err = CompileRule("close > vwap(1)"); err != nil || rule.Holds(rates) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,253 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"nuchal-api/db"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultInterval is how often the scheduler refreshes rates unless SCHEDULER_INTERVAL says otherwise.
+	defaultInterval = time.Minute
+
+	// refreshWindow is how far back each refresh looks for missing rates.
+	refreshWindow = time.Hour * 24
+)
+
+// Scheduler keeps the rates of watched products current, recording each run as a Job.
+type Scheduler struct {
+	mu   sync.Mutex
+	stop chan struct{}
+
+	// done is closed once the loop of the latest start has returned, so a restart cannot run alongside it.
+	done chan struct{}
+
+	interval time.Duration
+	watch    []string
+	started  time.Time
+	last     *Job
+}
+
+// SchedulerStatus is a snapshot of the Scheduler.
+type SchedulerStatus struct {
+	Running  bool          `json:"running"`
+	Interval time.Duration `json:"interval"`
+	Watch    []string      `json:"watch"`
+	Products []string      `json:"products"`
+	Started  time.Time     `json:"started"`
+	Last     *Job          `json:"last"`
+}
+
+var scheduler = &Scheduler{interval: defaultInterval}
+
+var (
+	errSchedulerRunning = errors.New("scheduler is already running")
+	errSchedulerStopped = errors.New("scheduler is not running")
+)
+
+func init() {
+	if interval, err := time.ParseDuration(env("SCHEDULER_INTERVAL")); err == nil && interval > 0 {
+		scheduler.interval = interval
+	}
+	scheduler.watch = splitProducts(env("WATCH_PRODUCTS"))
+}
+
+// BootScheduler starts the scheduler with its configured interval and watch list, unless SCHEDULER_ENABLED is false.
+func BootScheduler() error {
+	if enabled, err := strconv.ParseBool(env("SCHEDULER_ENABLED")); err == nil && !enabled {
+		log.Info().Msg("scheduler disabled")
+		return nil
+	}
+	return StartScheduler(0, nil)
+}
+
+// StartScheduler refreshes rates from the public market every interval, for the watched products plus every product
+// of a pattern or an enabled buy session. A zero interval or empty watch list keeps the configured one. When the
+// scheduler was just stopped the first run waits for the last one to finish.
+func StartScheduler(interval time.Duration, watch []string) error {
+
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	if scheduler.stop != nil {
+		return errSchedulerRunning
+	}
+
+	if interval > 0 {
+		scheduler.interval = interval
+	}
+	if len(watch) > 0 {
+		scheduler.watch = watch
+	}
+	scheduler.started = time.Now()
+	scheduler.stop = make(chan struct{})
+
+	prior := scheduler.done
+	scheduler.done = make(chan struct{})
+
+	go scheduler.loop(prior, scheduler.stop, scheduler.done, scheduler.interval)
+
+	log.Info().
+		Dur("interval", scheduler.interval).
+		Strs("watch", scheduler.watch).
+		Msg("scheduler started")
+
+	return nil
+}
+
+// StopScheduler stops the scheduler after any run in progress.
+func StopScheduler() error {
+
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	if scheduler.stop == nil {
+		return errSchedulerStopped
+	}
+
+	close(scheduler.stop)
+	scheduler.stop = nil
+
+	log.Info().Msg("scheduler stopped")
+
+	return nil
+}
+
+func GetSchedulerStatus() SchedulerStatus {
+
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	return SchedulerStatus{
+		Running:  scheduler.stop != nil,
+		Interval: scheduler.interval,
+		Watch:    scheduler.watch,
+		Products: scheduler.products(),
+		Started:  scheduler.started,
+		Last:     scheduler.last,
+	}
+}
+
+func (s *Scheduler) loop(prior, stop, done chan struct{}, interval time.Duration) {
+
+	defer close(done)
+
+	if prior != nil {
+		select {
+		case <-stop:
+			return
+		case <-prior:
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.run()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run refreshes the product catalog once a day, then the rates of every scheduled product, recording the refresh as a
+// Job only when it fetched rates or failed.
+func (s *Scheduler) run() {
+
+	catalog := &Job{JobType: InitAllCBProducts}
+	if catalog.isTimeToDoJob() {
+		if err := catalog.run(); err != nil {
+			log.Err(err).Stack().Send()
+		}
+	}
+
+	refresh := &Job{JobType: RefreshRates}
+	if err := refresh.perform(); err != nil {
+		log.Err(err).Stack().Send()
+	}
+	if refresh.fetched || refresh.Status == jobFailed {
+		db.Resolve().Create(refresh)
+	}
+
+	s.mu.Lock()
+	s.last = refresh
+	s.mu.Unlock()
+}
+
+// products is the watch list plus the products of every pattern and enabled buy session, without duplicates.
+func (s *Scheduler) products() []string {
+
+	var patterns, sessions []string
+
+	db.Resolve().
+		Model(&Pattern{}).
+		Distinct("product_id").
+		Pluck("product_id", &patterns)
+
+	db.Resolve().
+		Model(&BuySession{}).
+		Where("enabled = ?", true).
+		Distinct("product_id").
+		Pluck("product_id", &sessions)
+
+	seen := map[string]bool{}
+	var products []string
+	for _, group := range [][]string{s.watch, patterns, sessions} {
+		for _, productID := range group {
+			if productID != "" && !seen[productID] {
+				seen[productID] = true
+				products = append(products, productID)
+			}
+		}
+	}
+
+	return products
+}
+
+// refreshRates backfills the last day of minute rates for every scheduled product, failing if any product fails.
+func (j *Job) refreshRates() error {
+
+	scheduler.mu.Lock()
+	products := scheduler.products()
+	scheduler.mu.Unlock()
+
+	omega := time.Now()
+	alpha := omega.Add(-refreshWindow)
+
+	var failed []string
+	for _, productID := range products {
+		backfill, err := BackfillRates(productID, alpha.Unix(), omega.Unix(), Minute)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", productID, err))
+		}
+		if backfill.Fetched > 0 {
+			j.fetched = true
+		}
+	}
+
+	j.Message = fmt.Sprintf("refreshed %d of %d products", len(products)-len(failed), len(products))
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+func splitProducts(s string) []string {
+	var products []string
+	for _, productID := range strings.Split(s, ",") {
+		if productID = strings.TrimSpace(productID); productID != "" {
+			products = append(products, productID)
+		}
+	}
+	return products
+}
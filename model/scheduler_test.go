@@ -0,0 +1,67 @@
+package model
+
+import (
+	"nuchal-api/util"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+
+	if err := StartScheduler(time.Hour, []string{productID}); err != nil {
+		t.Fail()
+	}
+
+	if err := StartScheduler(time.Hour, nil); err != errSchedulerRunning {
+		t.Fail()
+	}
+
+	status := GetSchedulerStatus()
+	if !status.Running || status.Interval != time.Hour || status.Watch[0] != productID {
+		t.Fail()
+	}
+
+	if err := StopScheduler(); err != nil {
+		t.Fail()
+	}
+
+	if err := StopScheduler(); err != errSchedulerStopped {
+		t.Fail()
+	}
+
+	util.PrettyPrint(GetSchedulerStatus())
+}
+
+func TestSchedulerWaitsForPriorRun(t *testing.T) {
+
+	s := &Scheduler{}
+	prior, stop, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+
+	go s.loop(prior, stop, done, time.Hour)
+
+	select {
+	case <-done:
+		t.Fail()
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// stopped before the prior run finished, it never runs
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fail()
+	}
+
+	if s.last != nil {
+		t.Fail()
+	}
+}
+
+func TestSplitProducts(t *testing.T) {
+	products := splitProducts(" BTC-USD,,ETH-USD ")
+	if len(products) != 2 || products[0] != "BTC-USD" || products[1] != "ETH-USD" {
+		t.Fail()
+	}
+}
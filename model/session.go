@@ -9,6 +9,7 @@ import (
 	"nuchal-api/db"
 	"nuchal-api/util"
 	"strings"
+	"time"
 )
 
 type SessionOutcome int
@@ -22,6 +23,7 @@ const (
 	buyOutcome
 	disabledOutcome
 	boundOutcome
+	exitOutcome
 )
 
 type Sessions struct {
@@ -42,16 +44,39 @@ type BuySession struct {
 	Session
 	PatternID uint `json:"pattern_id"`
 	Enabled   bool `json:"enabled"`
+
+	// Revision is the revision of the pattern the session buys by, so later edits do not change it.
+	Revision int `json:"revision"`
 }
 
 type SellSession struct {
 	Session
+	PatternID uint `json:"pattern_id"`
+
+	// Revision is the revision of the pattern that entered.
+	Revision int `json:"revision"`
+
 	Price float64 `json:"price"`
 	Goal  float64 `json:"goal"`
 	Even  float64 `json:"even"`
 	Loss  float64 `json:"loss"`
 	Maker float64 `json:"maker"`
 	Taker float64 `json:"taker"`
+
+	// OrderID is the stop loss order currently anchoring the session, if any.
+	OrderID string `json:"order_id"`
+
+	// Exit is the exit rule of the pattern that entered, selling at market once it holds on a closed rate.
+	Exit string `json:"exit"`
+
+	// Strategy is the exit strategy of the pattern that entered.
+	Strategy Strategy `json:"strategy" gorm:"embedded;embeddedPrefix:strategy_"`
+
+	exit   *Rule
+	window []Rate
+
+	// sold is the size a take profit limit sold before it was withdrawn.
+	sold float64
 }
 
 type SessionResult struct {
@@ -91,27 +116,44 @@ func DisableBuySession(ID uint) {
 	db.Resolve().Save(&s)
 }
 
-func EnableBuySession(ID uint) {
+// EnableBuySession restarts a buy session, refusing one whose pattern is invalid.
+func EnableBuySession(ID uint) error {
 	var s BuySession
 	db.Resolve().First(&s, ID)
+	pattern, err := s.pattern()
+	if err != nil {
+		return err
+	}
+	if err = pattern.Validate(); err != nil {
+		return err
+	}
 	s.Enabled = true
 	db.Resolve().Save(&s)
 	go s.buy()
+	return nil
 }
 
 /*
-	session methods
+session methods
 */
 func (s *SellSession) errorResult(logger *zerolog.Logger, err error) {
 	logger.Err(err).Send()
 	s.Results = append(s.Results, SessionResult{SessionID: s.ID, Error: err.Error(), Outcome: errorOutcome})
 	db.Resolve().Save(s)
+	s.publish(errorEvent, 0, err)
 }
 
 func (s *BuySession) errorResult(logger *zerolog.Logger, err error) {
 	logger.Err(err).Send()
 	s.Results = append(s.Results, SessionResult{SessionID: s.ID, Error: err.Error(), Outcome: errorOutcome})
 	db.Resolve().Save(s)
+	s.publish(errorEvent, 0, err)
+}
+
+// feed returns the websocket feed URL of the session user.
+func (s *Session) feed() string {
+	u := FindUserByID(s.UserID)
+	return u.Endpoint().Feed
 }
 
 func (s *Session) precise(f float64) string {
@@ -126,12 +168,22 @@ func (s *Session) precise(f float64) string {
 	buy session methods
 */
 
-func StartBuySession(patternID uint) {
+// StartBuySession starts buying by a pattern, refusing a pattern that is invalid.
+func StartBuySession(patternID uint) error {
 
 	pattern := FindPatternByID(patternID)
+	if err := pattern.Validate(); err != nil {
+		return err
+	}
+
+	if err := pattern.ensureRevision(); err != nil {
+		return err
+	}
+
 	session := &BuySession{
 		Enabled:   true,
 		PatternID: patternID,
+		Revision:  pattern.Revision,
 		Session: Session{
 			ProductID: pattern.ProductID,
 			UserID:    pattern.UserID,
@@ -143,6 +195,8 @@ func StartBuySession(patternID uint) {
 	db.Resolve().Create(&session)
 
 	go session.buy()
+
+	return nil
 }
 
 func (s *BuySession) isEnabled() bool {
@@ -164,7 +218,8 @@ func (s *BuySession) buy() {
 	var pipe *Pipe
 	var err error
 
-	if pipe, err = NewPipe(s.ProductID); err != nil {
+	// following the book for the whole session keeps the spread of an entry at hand
+	if pipe, err = NewBookPipe(s.feed(), s.ProductID); err != nil {
 		s.errorResult(s.log(), err)
 		return
 	}
@@ -175,41 +230,61 @@ func (s *BuySession) buy() {
 		}
 	}(pipe)
 
-	var then, that, this Rate
+	var pattern Pattern
+	if pattern, err = s.pattern(); err != nil {
+		s.errorResult(s.log(), err)
+		return
+	}
+
+	var window []Rate
 	for {
 
 		if !s.Enabled {
 			s.log().Info().Msg("disabled")
 			s.Results = append(s.Results, SessionResult{SessionID: s.ID, Outcome: disabledOutcome})
 			db.Resolve().Save(s)
+			s.publish(disabledEvent, 0, nil)
 			return
 		}
 
-		pattern := FindPatternByID(s.PatternID)
-
 		if pattern.Bound == buyBound && s.getBuyCount() >= pattern.Bind {
 			s.log().Info().Msg("bound")
 			s.Results = append(s.Results, SessionResult{SessionID: s.ID, Outcome: boundOutcome})
 			db.Resolve().Save(s)
+			s.publish(boundEvent, 0, nil)
 			return
 		}
 
+		var this Rate
 		if this, err = pipe.getRate(); err != nil {
 			if err = pipe.Reopen(); err != nil {
 				s.errorResult(s.log(), err)
 				return
 			}
-			then = Rate{}
-			that = Rate{}
+			window = nil
 			continue
 		}
 
-		if pattern.MatchesTweezerBottomPattern(then, that, this) {
+		if window == nil {
+			window = pattern.history()
+		}
+
+		if window = append(window, this); len(window) > pattern.lookback() {
+			window = window[len(window)-pattern.lookback():]
+		}
+
+		if pattern.Matches(window) {
+
+			if reason, vetoed := pattern.veto(window); vetoed {
+				s.log().Debug().Str("reason", reason).Msg("pattern vetoed")
+				continue
+			}
 
 			s.log().Debug().Msg("pattern found!")
+			s.publish(patternEvent, this.Close, nil)
 
 			var price, size float64
-			if price, size, err = s.camp(); err != nil {
+			if price, size, err = s.camp(pipe, pattern); err != nil {
 				s.log().Debug().Msg("error camping")
 				s.errorResult(s.log(), err)
 				return
@@ -219,15 +294,13 @@ func (s *BuySession) buy() {
 
 			s.Results = append(s.Results, SessionResult{SessionID: s.ID, Price: price, Outcome: buyOutcome})
 			db.Resolve().Save(s)
+			s.publish(campEvent, price, nil)
 
 			go startSellSession(price, size, pattern)
 
 		} else {
-			s.log().Debug().Msg("!tweezer")
+			s.log().Debug().Str("formation", string(pattern.Formation)).Msg("no match")
 		}
-
-		then = that
-		that = this
 	}
 
 }
@@ -242,34 +315,62 @@ func (s *BuySession) getBuyCount() int64 {
 	return count
 }
 
-func (s *BuySession) camp() (float64, float64, error) {
+// pattern is the revision of the pattern the session started with.
+func (s *BuySession) pattern() (Pattern, error) {
+	return FindPatternAt(s.PatternID, s.Revision)
+}
+
+func (s *BuySession) camp(pipe *Pipe, pattern Pattern) (float64, float64, error) {
+
+	bid, ask, err := pipe.getSpread()
+	if err != nil {
+		s.log().Warn().Err(err).Msg("camping without a spread")
+	} else {
+		s.log().Debug().Float64("bid", bid).Float64("ask", ask).Float64("spread", ask-bid).Msg("camping")
+	}
+
+	price := ask
+	if price == 0 {
+		if price, err = pipe.getPrice(); err != nil {
+			s.log().Err(err).Msg("pricing a camp order")
+			return 0, 0, err
+		}
+	}
+
+	available, err := pattern.balance()
+	if err != nil {
+		s.log().Err(err).Msg("sizing a camp order")
+		return 0, 0, err
+	}
 
 	u := FindUserByID(s.UserID)
 
-	order, err := u.Client().CreateOrder(&cb.Order{
-		ProductID: s.ProductID,
-		Side:      "buy",
-		Size:      s.precise(s.Size),
-		Type:      "market",
-	})
+	entry, err := pattern.entryOrder(price, available)
+	if err != nil {
+		s.log().Err(err).Msg("sizing a camp order")
+		return 0, 0, err
+	}
+
+	order, err := u.Client().CreateOrder(&entry)
 
 	if err != nil {
 		s.log().Err(err).Msg("creating a camp order")
 		return 0, 0, err
 	}
 
-	if order, err = u.Client().GetOrder(order.ID); err != nil {
+	// an order for funds is only sized as it fills
+	if order, err = awaitFill(u.Client(), order.ID, fillTimeout); err != nil {
 		s.log().Err(err).Msg("getting a camp order")
 		return 0, 0, err
 	}
 
-	size := util.StringToFloat64(order.Size)
-	price := util.StringToFloat64(s.precise(util.StringToFloat64(order.ExecutedValue) / size))
+	size := util.StringToFloat64(order.FilledSize)
+	price = util.StringToFloat64(s.precise(util.StringToFloat64(order.ExecutedValue) / size))
 	return price, size, nil
 }
 
 /*
-	sell session methods
+sell session methods
 */
 func StartSellSession(price, size float64, productID string) {
 	go startSellSession(price, size, FindFirstPatternByProductID(productID))
@@ -278,18 +379,22 @@ func StartSellSession(price, size float64, productID string) {
 func startSellSession(price, size float64, pattern Pattern) {
 
 	session := &SellSession{
+		PatternID: pattern.ID,
+		Revision:  pattern.Revision,
 		Session: Session{
 			UserID:    pattern.UserID,
 			ProductID: pattern.ProductID,
 			Size:      size,
 			Step:      pattern.Product.Step,
 		},
-		Price: price,
-		Goal:  pattern.GoalPrice(price),
-		Even:  pattern.EvenPrice(price),
-		Loss:  pattern.LossPrice(price),
-		Maker: pattern.User.Maker,
-		Taker: pattern.User.Taker,
+		Price:    price,
+		Goal:     pattern.GoalPrice(price),
+		Even:     pattern.EvenPrice(price),
+		Loss:     pattern.LossPrice(price),
+		Maker:    pattern.User.Maker,
+		Taker:    pattern.User.Taker,
+		Exit:     pattern.Exit,
+		Strategy: pattern.Strategy,
 	}
 
 	db.Resolve().Create(&session)
@@ -316,6 +421,11 @@ func (s *SellSession) log() *zerolog.Logger {
 
 func (s *SellSession) sell() {
 
+	if s.Strategy.trails() {
+		s.follow()
+		return
+	}
+
 	s.log().Debug().Msg("sell")
 
 	var orderID string
@@ -328,8 +438,9 @@ func (s *SellSession) sell() {
 	}
 
 	s.log().Debug().Str("orderID", orderID).Msg("initial anchor set")
+	s.publish(anchorEvent, s.Price, nil)
 
-	if pipe, err = NewPipe(s.ProductID); err != nil {
+	if pipe, err = NewPipe(s.feed(), s.ProductID); err != nil {
 		s.errorResult(s.log(), err)
 		return
 	}
@@ -342,14 +453,26 @@ func (s *SellSession) sell() {
 		}
 	}(pipe, err)
 
+	s.watch()
+
 	for {
 
 		var price float64
 
 		if price, err = pipe.getPrice(); err != nil {
 			s.log().Debug().Str("orderID", orderID).Msg("error getting price to find goal")
-			s.errorResult(s.log(), err)
-			return
+			if err = pipe.Reopen(); err != nil {
+				s.errorResult(s.log(), err)
+				return
+			}
+			continue
+		}
+
+		for _, rate := range pipe.closedRates() {
+			if s.exits(rate) {
+				s.exitResult(orderID, rate.Close)
+				return
+			}
 		}
 
 		if price <= s.Loss {
@@ -380,6 +503,7 @@ func (s *SellSession) sell() {
 		}
 
 		s.log().Debug().Float64("price", price).Str("orderID", orderID).Msg("new stop loss created")
+		s.publish(reanchorEvent, price, nil)
 
 		/*
 			TIME TO CLIMB BABY WOOOOOOT
@@ -391,8 +515,11 @@ func (s *SellSession) sell() {
 
 			if rate, err = pipe.getRate(); err != nil {
 				s.log().Debug().Msg("error getting price to find gain")
-				s.errorResult(s.log(), err)
-				return
+				if err = pipe.Reopen(); err != nil {
+					s.errorResult(s.log(), err)
+					return
+				}
+				continue
 			}
 
 			l := s.log().Hook(rate)
@@ -404,6 +531,11 @@ func (s *SellSession) sell() {
 				return
 			}
 
+			if s.exits(rate) {
+				s.exitResult(orderID, rate.Close)
+				return
+			}
+
 			if rate.Close > price {
 
 				l.Debug().Msg("rate.close > price")
@@ -424,6 +556,7 @@ func (s *SellSession) sell() {
 
 				// the new price to beat
 				price = rate.Close
+				s.publish(reanchorEvent, price, nil)
 				l.Debug().Msg("price == rate.close")
 			}
 		}
@@ -431,19 +564,26 @@ func (s *SellSession) sell() {
 }
 
 func (s *SellSession) anchor() (string, error) {
+	return s.anchorAt(s.Price)
+}
+
+// anchorAt places a stop loss at the price.
+func (s *SellSession) anchorAt(price float64) (string, error) {
 	u := FindUserByID(s.UserID)
 	order, err := u.Client().CreateOrder(&cb.Order{
 		ProductID: s.ProductID,
-		Price:     s.precise(s.Price),
+		Price:     s.precise(price),
 		Side:      "sell",
-		Size:      s.precise(s.Size),
+		Size:      s.precise(s.unsold()),
 		Type:      "limit",
-		StopPrice: s.precise(s.Price),
+		StopPrice: s.precise(price),
 		Stop:      "loss",
 	})
 	if err != nil {
 		return "", err
 	}
+	s.OrderID = order.ID
+	db.Resolve().Save(s)
 	return order.ID, nil
 }
 
@@ -464,25 +604,331 @@ func (s *SellSession) anchorOrExit() (string, error) {
 	return "", err
 }
 
+// follow sells by the session's exit strategy, keeping a stop loss anchored at the strategy's stop and
+// re-anchoring it as the stop rises with each closed rate.
+func (s *SellSession) follow() {
+
+	s.log().Debug().Str("strategy", string(s.Strategy.Exit)).Msg("follow")
+
+	s.watch()
+
+	var prior []Rate
+	if s.Strategy.Exit == ATRExit {
+		prior = history(s.ProductID, s.Strategy.Period*3)
+	}
+
+	e := newExit(s.Strategy, s.Price, s.Goal, s.Loss, time.Now(), Minute, prior)
+
+	orderID, err := s.anchorAt(e.stop)
+	if err != nil {
+		s.errorResult(s.log(), err)
+		return
+	}
+
+	stop := e.stop
+	s.publish(anchorEvent, stop, nil)
+
+	pipe, err := NewPipe(s.feed(), s.ProductID)
+	if err != nil {
+		s.errorResult(s.log(), err)
+		return
+	}
+
+	defer func(pipe *Pipe) {
+		if err := pipe.Close(); err != nil {
+			s.errorResult(s.log(), err)
+		}
+	}(pipe)
+
+	// limitID is the take profit limit resting in place of the stop loss while the price is at the limit
+	var limitID string
+
+	// checkAt is when the limit may next be checked or changed, later after each call to the exchange that fails
+	var checkAt time.Time
+	var failures int
+
+	retry := func(err error, msg string) {
+		failures++
+		checkAt = time.Now().Add(backoff(failures))
+		s.log().Warn().Err(err).Int("failures", failures).Msg(msg)
+	}
+
+	for {
+
+		var price float64
+
+		if price, err = pipe.getPrice(); err != nil {
+			if err = pipe.Reopen(); err != nil {
+				s.errorResult(s.log(), err)
+				return
+			}
+			continue
+		}
+
+		if limitID != "" {
+
+			if time.Now().Before(checkAt) {
+				continue
+			}
+
+			var filled bool
+
+			if price >= e.limit() {
+
+				if filled, err = s.limitFilled(limitID); err != nil {
+					retry(err, "checking limit")
+					continue
+				}
+
+				if filled {
+					s.goalResult()
+					return
+				}
+
+				failures = 0
+				checkAt = time.Now().Add(limitPoll)
+				continue
+			}
+
+			// the price fell back before the limit filled, so the stop loss anchors the session again
+			s.log().Debug().Float64("price", price).Str("limitID", limitID).Msg("price < limit")
+
+			if filled, err = s.withdraw(limitID); err != nil {
+				retry(err, "withdrawing limit")
+				continue
+			}
+
+			if filled {
+				s.goalResult()
+				return
+			}
+
+			limitID = ""
+			failures = 0
+
+			if orderID, err = s.anchorAt(stop); err != nil {
+				s.errorResult(s.log(), err)
+				return
+			}
+
+			s.publish(reanchorEvent, stop, nil)
+		}
+
+		if price <= stop {
+			s.log().Debug().Float64("price", price).Float64("stop", stop).Msg("price <= stop")
+			s.stopResult(stop)
+			return
+		}
+
+		if limit := e.limit(); limit > 0 && price >= limit && !time.Now().Before(checkAt) {
+
+			s.log().Debug().Float64("price", price).Float64("limit", limit).Msg("price >= limit")
+
+			if limitID, err = s.takeProfit(orderID, stop, limit); err != nil {
+				if s.OrderID == "" {
+					s.errorResult(s.log(), err)
+					return
+				}
+				// the stop loss still anchors the session
+				orderID = s.OrderID
+				retry(err, "taking profit")
+				continue
+			}
+
+			orderID = ""
+			failures = 0
+			checkAt = time.Now().Add(limitPoll)
+			continue
+		}
+
+		for _, rate := range pipe.closedRates() {
+
+			if s.exits(rate) || e.next(rate) {
+				s.exitResult(orderID, rate.Close)
+				return
+			}
+
+			if e.stop > stop {
+
+				if err = s.cancelOrder(orderID); err != nil {
+					s.errorResult(s.log(), err)
+					return
+				}
+
+				if orderID, err = s.anchorAt(e.stop); err != nil {
+					s.errorResult(s.log(), err)
+					return
+				}
+
+				stop = e.stop
+				s.publish(reanchorEvent, stop, nil)
+			}
+		}
+	}
+}
+
+// stopResult records the sale of the stop loss, a gain when the stop had risen above the entry price.
+func (s *SellSession) stopResult(stop float64) {
+	if stop > s.Price {
+		s.gainResult(stop)
+		return
+	}
+	s.log().Info().Msg("loss")
+	s.Results = append(s.Results, SessionResult{SessionID: s.ID, Price: stop, Outcome: lossOutcome})
+	db.Resolve().Save(s)
+	s.publish(lossEvent, stop, nil)
+}
+
+// takeProfit swaps the stop loss for a limit at the take profit price, returning the limit's order ID. The stop
+// and the limit cannot both hold the size, so the stop is canceled first and anchored again at the stop price if
+// the limit cannot be placed, leaving the session without either only when that fails too.
+func (s *SellSession) takeProfit(orderID string, stop, limit float64) (string, error) {
+
+	if err := s.cancelOrder(orderID); err != nil {
+		return "", err
+	}
+
+	u := FindUserByID(s.UserID)
+	order, err := u.Client().CreateOrder(&cb.Order{
+		ProductID: s.ProductID,
+		Price:     s.precise(limit),
+		Side:      "sell",
+		Size:      s.precise(s.unsold()),
+		Type:      "limit",
+	})
+	if err != nil {
+		if _, anchorErr := s.anchorAt(stop); anchorErr != nil {
+			return "", fmt.Errorf("%v; anchoring the stop again: %v", err, anchorErr)
+		}
+		return "", err
+	}
+
+	s.OrderID = order.ID
+	db.Resolve().Save(s)
+	return order.ID, nil
+}
+
+// limitFilled reports whether a take profit limit has filled.
+func (s *SellSession) limitFilled(limitID string) (bool, error) {
+	u := FindUserByID(s.UserID)
+	order, err := u.Client().GetOrder(limitID)
+	if err != nil {
+		return false, err
+	}
+	return order.Status == "done" && order.DoneReason == "filled", nil
+}
+
+// withdraw cancels a take profit limit, reporting whether it filled first. Whatever part of it filled is sold.
+func (s *SellSession) withdraw(limitID string) (bool, error) {
+
+	if err := s.cancelOrder(limitID); err != nil {
+		// it may have filled since
+		if filled, _ := s.limitFilled(limitID); filled {
+			return true, nil
+		}
+		return false, err
+	}
+
+	// the limit can fill up to the moment it is canceled, so only now is its filled size final
+	u := FindUserByID(s.UserID)
+	for attempt := 0; ; attempt++ {
+		order, err := u.Client().GetOrder(limitID)
+		if err == nil {
+			s.sold += util.StringToFloat64(order.FilledSize)
+			return false, nil
+		}
+		if notFound(err) {
+			// a limit canceled before any of it filled is gone
+			return false, nil
+		}
+		s.log().Warn().Err(err).Str("limitID", limitID).Msg("reading withdrawn limit")
+		time.Sleep(backoff(attempt))
+	}
+}
+
+// unsold is the size the session has left to sell.
+func (s *SellSession) unsold() float64 {
+	return s.Size - s.sold
+}
+
+// watch compiles the exit rule of the session, if it has one, and loads the rates it reads.
+func (s *SellSession) watch() {
+	if strings.TrimSpace(s.Exit) == "" {
+		return
+	}
+	var err error
+	if s.exit, err = compileRule(exitRule, s.Exit); err != nil {
+		s.log().Err(err).Send()
+		return
+	}
+	s.window = history(s.ProductID, s.exit.lookback())
+}
+
+// exits reports whether the exit rule holds on a rate that just closed.
+func (s *SellSession) exits(rate Rate) bool {
+	if s.exit == nil {
+		return false
+	}
+	if s.window = append(s.window, rate); len(s.window) > s.exit.lookback() {
+		s.window = s.window[len(s.window)-s.exit.lookback():]
+	}
+	return s.exit.Holds(s.window)
+}
+
+// exitResult cancels the anchoring stop loss and sells at market because the exit rule holds.
+func (s *SellSession) exitResult(orderID string, price float64) {
+
+	if orderID != "" {
+		if err := s.cancelOrder(orderID); err != nil {
+			s.errorResult(s.log(), err)
+			return
+		}
+	}
+
+	u := FindUserByID(s.UserID)
+	if _, err := u.Client().CreateOrder(&cb.Order{
+		ProductID: s.ProductID,
+		Side:      "sell",
+		Size:      s.precise(s.unsold()),
+		Type:      "market",
+	}); err != nil {
+		s.errorResult(s.log(), err)
+		return
+	}
+
+	s.log().Info().Msg("exit")
+	s.Results = append(s.Results, SessionResult{SessionID: s.ID, Price: price, Outcome: exitOutcome})
+	db.Resolve().Save(s)
+	s.publish(exitEvent, price, nil)
+}
+
 func (s *SellSession) cancelOrder(orderID string) error {
 	u := FindUserByID(s.UserID)
-	return u.Client().CancelOrder(orderID)
+	if err := u.Client().CancelOrder(orderID); err != nil {
+		return err
+	}
+	s.OrderID = ""
+	db.Resolve().Save(s)
+	return nil
 }
 
 func (s *SellSession) lossResult() {
 	s.log().Info().Msg("loss")
 	s.Results = append(s.Results, SessionResult{SessionID: s.ID, Price: s.Loss, Outcome: lossOutcome})
 	db.Resolve().Save(s)
+	s.publish(lossEvent, s.Loss, nil)
 }
 
 func (s *SellSession) goalResult() {
 	s.log().Info().Msg("goal")
 	s.Results = append(s.Results, SessionResult{SessionID: s.ID, Price: s.Goal, Outcome: goalOutcome})
 	db.Resolve().Save(s)
+	s.publish(goalEvent, s.Goal, nil)
 }
 
 func (s *SellSession) gainResult(price float64) {
 	s.log().Info().Msg("gain")
 	s.Results = append(s.Results, SessionResult{SessionID: s.ID, Price: price, Outcome: gainOutcome})
 	db.Resolve().Save(s)
+	s.publish(gainEvent, price, nil)
 }
@@ -2,6 +2,7 @@ package model
 
 import (
 	"fmt"
+	"math"
 
 	"nuchal-api/util"
 	"time"
@@ -19,6 +20,14 @@ type Analysis struct {
 	Return     string    `json:"return"`
 	Percent    string    `json:"percent"`
 	Summaries  []Summary `json:"summaries"`
+	Vetoes     []Veto    `json:"vetoes"`
+}
+
+// Veto is a formation match that a filter or the entry rule of the pattern kept from entering.
+type Veto struct {
+	Time   string `json:"time"`
+	Price  string `json:"price"`
+	Reason string `json:"reason"`
 }
 
 type Summary struct {
@@ -56,6 +65,15 @@ const (
 
 	// upType when the trade doesn't finish and ends down
 	upType = "up"
+
+	// exitType when the exit rule of the pattern sells at the close
+	exitType = "exit"
+
+	// stopType when the stop of the pattern's exit strategy sells
+	stopType = "stop"
+
+	// timeType when the time exit of the pattern sells at the close
+	timeType = "time"
 )
 
 type MockTrade struct {
@@ -66,6 +84,12 @@ type MockTrade struct {
 	Pattern Pattern   `json:"-"`
 	Maker   float64   `json:"maker"`
 	Taker   float64   `json:"taker"`
+
+	// Size is the base size the pattern's sizing bought at entry.
+	Size float64 `json:"size"`
+
+	// stop is the price a stopType trade sold at.
+	stop float64
 }
 
 func newTrade(index int64, pattern Pattern) *MockTrade {
@@ -98,8 +122,10 @@ func (t *MockTrade) out() float64 {
 		return t.goal()
 	} else if t.Type == humpType {
 		return t.Sell.Open
-	} else if t.Type == downType || t.Type == upType {
+	} else if t.Type == downType || t.Type == upType || t.Type == exitType || t.Type == timeType {
 		return t.Sell.Close
+	} else if t.Type == stopType {
+		return t.stop
 	} else {
 		return 0.0
 	}
@@ -118,6 +144,12 @@ func (t *MockTrade) color() string {
 		return "#FF9800"
 	} else if t.Type == upType {
 		return "#CDDC39"
+	} else if t.Type == exitType {
+		return "#03A9F4"
+	} else if t.Type == stopType {
+		return "#9C27B0"
+	} else if t.Type == timeType {
+		return "#607D8B"
 	} else {
 		return "#757575"
 	}
@@ -136,6 +168,12 @@ func (t *MockTrade) text() string {
 		return fmt.Sprintf("%d - %s", t.Index, `📉`)
 	} else if t.Type == upType {
 		return fmt.Sprintf("%d - %s", t.Index, `📈`)
+	} else if t.Type == exitType {
+		return fmt.Sprintf("%d - %s", t.Index, `🚪`)
+	} else if t.Type == stopType {
+		return fmt.Sprintf("%d - %s", t.Index, `🛑`)
+	} else if t.Type == timeType {
+		return fmt.Sprintf("%d - %s", t.Index, `⏰`)
 	} else {
 		return fmt.Sprintf("%d", t.Index)
 	}
@@ -154,6 +192,12 @@ func (t *MockTrade) emoji() string {
 		return `📉`
 	} else if t.Type == upType {
 		return `📈`
+	} else if t.Type == exitType {
+		return `🚪`
+	} else if t.Type == stopType {
+		return `🛑`
+	} else if t.Type == timeType {
+		return `⏰`
 	} else {
 		return ``
 	}
@@ -174,7 +218,7 @@ func (t *MockTrade) even() float64 {
 }
 
 func (t *MockTrade) investment() float64 {
-	return t.entry() * t.Pattern.Size
+	return t.entry() * t.Size
 }
 
 func (t *MockTrade) fees() float64 {
@@ -190,7 +234,7 @@ func (t *MockTrade) gross() float64 {
 }
 
 func (t *MockTrade) profit() float64 {
-	return (t.exit() - t.entry()) * t.Pattern.Size
+	return (t.exit() - t.entry()) * t.Size
 }
 
 func (t *MockTrade) percent() float64 {
@@ -228,12 +272,17 @@ func (t *MockTrade) summary() Summary {
 	}
 }
 
-func NewSim(patternID uint, alpha, omega int64) (sim Sim, err error) {
+// NewSim simulates a revision of a pattern over the rates between alpha and omega, the current pattern when the
+// revision is zero.
+func NewSim(patternID uint, revision int, alpha, omega int64, granularity Granularity) (sim Sim, err error) {
 
-	pattern := FindPatternByID(patternID)
+	var pattern Pattern
+	if pattern, err = FindPatternAt(patternID, revision); err != nil {
+		return
+	}
 
 	var rates []Rate
-	if rates, err = GetRates(pattern.UserID, pattern.ProductID, alpha, omega); err != nil {
+	if rates, err = GetRates(pattern.ProductID, alpha, omega, granularity); err != nil {
 		return
 	}
 
@@ -243,20 +292,38 @@ func NewSim(patternID uint, alpha, omega int64) (sim Sim, err error) {
 	}
 
 	var summaries []Summary
+	var vetoes []Veto
 	var inv, roi, fee float64
-	var then, that Rate
 
-	for i, this := range rates {
+	lookback := pattern.lookback()
+
+	// sizing from a balance compounds the profit of each trade into the balance of the next, starting from a
+	// simulated balance rather than the live account
+	equity := pattern.simBalance()
+
+	for i := range rates {
 
 		index := int64(len(summaries))
 		if pattern.Bound == buyBound && index == pattern.Bind {
 			break
 		}
 
-		if pattern.MatchesTweezerBottomPattern(then, that, this) {
+		if pattern.Matches(rates[:i+1]) {
+
+			if reason, vetoed := pattern.veto(recent(rates[:i+1], lookback)); vetoed {
+				vetoes = append(vetoes, Veto{
+					Time:   rates[i].Time().UTC().Format(time.Stamp),
+					Price:  pattern.Product.precise(rates[i].Close),
+					Reason: reason,
+				})
+				continue
+			}
+
 			index++
 			trade := newTrade(index, pattern)
-			trade.em(rates[i+1:])
+			trade.em(rates, i+1, granularity)
+			trade.Size = pattern.baseSize(trade.in(), equity)
+			equity += trade.profit()
 			fee += trade.fees()
 			roi += trade.profit()
 			inv += trade.investment()
@@ -264,9 +331,6 @@ func NewSim(patternID uint, alpha, omega int64) (sim Sim, err error) {
 			splits = append(splits, trade.splitData()...)
 			summaries = append(summaries, trade.summary())
 		}
-
-		then = that
-		that = this
 	}
 
 	sim.Pattern = pattern
@@ -283,13 +347,32 @@ func NewSim(patternID uint, alpha, omega int64) (sim Sim, err error) {
 		util.FloatToUsd(roi),
 		util.FloatToDecimal(roi / inv * 100),
 		summaries,
+		vetoes,
 	}
 	return
 }
 
-func (t *MockTrade) em(rates []Rate) {
+// recent is the last n rates.
+func recent(rates []Rate, n int) []Rate {
+	if len(rates) > n {
+		return rates[len(rates)-n:]
+	}
+	return rates
+}
+
+// em plays the trade out over the rates from start, the rates before it being history for the exit rule.
+func (t *MockTrade) em(rates []Rate, start int, granularity Granularity) {
+
+	if t.Pattern.Strategy.trails() {
+		t.follow(rates, start, granularity)
+		return
+	}
+
+	hold := int(12 * time.Hour / granularity.Duration())
+	exit := t.Pattern.exitRule()
+	lookback := t.Pattern.lookback()
 
-	for i, rate := range rates {
+	for i, rate := range rates[start:] {
 
 		if i == 0 {
 			t.Buy = rate
@@ -333,13 +416,20 @@ func (t *MockTrade) em(rates []Rate) {
 			break
 		}
 
+		// the exit rule sells at the close
+		if exit != nil && exit.Holds(recent(rates[:start+i+1], lookback)) {
+			t.Type = exitType
+			t.Sell = rate
+			break
+		}
+
 		// if this is the first rate, give nuchal time orderType
 		if i == 0 {
 			continue
 		}
 
 		// if trading after 12 hours, try to break even
-		if i > 60*12 && rate.High >= t.even() {
+		if i > hold && rate.High >= t.even() {
 			t.Type = evenType
 			t.Sell = rate
 			break
@@ -356,3 +446,56 @@ func (t *MockTrade) em(rates []Rate) {
 		}
 	}
 }
+
+// follow plays the trade out over the rates from start by the pattern's exit strategy, as a sell session would.
+func (t *MockTrade) follow(rates []Rate, start int, granularity Granularity) {
+
+	if start >= len(rates) {
+		return
+	}
+
+	t.Buy = rates[start]
+
+	exitRule := t.Pattern.exitRule()
+	lookback := t.Pattern.lookback()
+	e := newExit(t.Pattern.Strategy, t.in(), t.goal(), t.loss(), t.Buy.Time(), granularity, recent(rates[:start], t.Pattern.Strategy.Period*3))
+
+	for i, rate := range rates[start:] {
+
+		t.Sell = rate
+
+		// the stop sells at its price, or at the open of a rate that gaps below it
+		if rate.Low <= e.stop {
+			t.Type = stopType
+			t.stop = math.Min(e.stop, rate.Open)
+			return
+		}
+
+		if limit := e.limit(); limit > 0 && rate.High >= limit {
+			t.Type = goalType
+			return
+		}
+
+		if exitRule != nil && exitRule.Holds(recent(rates[:start+i+1], lookback)) {
+			t.Type = exitType
+			return
+		}
+
+		if e.next(rate) {
+			if t.Pattern.Strategy.Exit == TimeExit {
+				t.Type = timeType
+			} else {
+				t.Type = stopType
+				t.stop = rate.Close
+			}
+			return
+		}
+	}
+
+	// we're holding the trade
+	if t.Sell.Close >= t.even() {
+		t.Type = upType
+	} else {
+		t.Type = downType
+	}
+}
@@ -7,7 +7,17 @@ import (
 
 func TestNewSim(t *testing.T) {
 
-	sim, err := NewSim(uint(20), alpha, omega)
+	sim, err := NewSim(uint(20), 0, alpha, omega, Minute)
+	if err != nil {
+		t.Fail()
+	}
+
+	util.PrettyPrint(sim.Analysis)
+}
+
+func TestNewSimHour(t *testing.T) {
+
+	sim, err := NewSim(uint(20), 0, alpha, omega, Hour)
 	if err != nil {
 		t.Fail()
 	}
@@ -0,0 +1,157 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"math"
+	"nuchal-api/util"
+)
+
+// Sizing names how a pattern reads its Size when sizing an entry.
+type Sizing string
+
+const (
+
+	// BaseSizing buys Size of the base currency.
+	BaseSizing Sizing = "base"
+
+	// QuoteSizing buys with Size of the quote currency, e.g. $50 an entry.
+	QuoteSizing = "quote"
+
+	// EquitySizing buys with a fraction Size of the available quote balance.
+	EquitySizing = "equity"
+
+	// RiskSizing buys as much as loses a fraction Size of the available quote balance should the price fall to the
+	// loss price, no more than the balance buys after the taker fee.
+	RiskSizing = "risk"
+)
+
+// validateSizing returns the field of the pattern's sizing that is invalid, if any.
+func (p *Pattern) validateSizing() (string, error) {
+	switch p.Sizing {
+	case "", BaseSizing, QuoteSizing:
+		if p.Size <= 0 {
+			return "size", errors.New("size must be positive")
+		}
+	case EquitySizing, RiskSizing:
+		if p.Size <= 0 || p.Size > 1 {
+			return "size", fmt.Errorf("%s sizing needs a size between 0 and 1", p.Sizing)
+		}
+		if p.Sizing == RiskSizing && p.Tolerance <= 0 {
+			return "tolerance", errors.New("risk sizing needs a tolerance")
+		}
+	default:
+		return "sizing", fmt.Errorf("unknown sizing %q", p.Sizing)
+	}
+	return "", nil
+}
+
+// needsBalance reports whether sizing an entry reads the available quote balance.
+func (p *Pattern) needsBalance() bool {
+	return p.Sizing == EquitySizing || p.Sizing == RiskSizing
+}
+
+// entrySize is the base size and quote funds of an entry at the price given the available quote balance,
+// only one of which is set: funds when the sizing is an amount of the quote currency.
+func (p *Pattern) entrySize(price, available float64) (size, funds float64) {
+	switch p.Sizing {
+	case QuoteSizing:
+		return 0, p.Size
+	case EquitySizing:
+		return 0, available * p.Size
+	case RiskSizing:
+		if price <= 0 {
+			return 0, 0
+		}
+		risk := price - p.LossPrice(price)
+		if risk <= 0 {
+			return 0, 0
+		}
+		size := math.Min(available*p.Size/risk, available/(price*(1+p.User.Taker)))
+		return p.Product.floor(size), 0
+	}
+	return p.Size, 0
+}
+
+// baseSize is the base size an entry at the price buys, estimating the size of funds at the price.
+func (p *Pattern) baseSize(price, available float64) float64 {
+	size, funds := p.entrySize(price, available)
+	if funds > 0 && price > 0 {
+		return funds / price
+	}
+	return size
+}
+
+// entryOrder is the market order of an entry at the price, failing when the base size it buys, estimated for funds,
+// is outside the product's minimum and maximum.
+func (p *Pattern) entryOrder(price, available float64) (cb.Order, error) {
+	order := cb.Order{ProductID: p.ProductID, Side: "buy", Type: "market"}
+	if err := p.Product.checkSize(p.baseSize(price, available)); err != nil {
+		return order, err
+	}
+	if size, funds := p.entrySize(price, available); funds > 0 {
+		order.Funds = util.FloatToDecimal(funds)
+	} else {
+		order.Size = p.Product.precise(size)
+	}
+	return order, nil
+}
+
+// checkSize fails a base size outside the product's minimum and maximum, when it has them.
+func (p *Product) checkSize(size float64) error {
+	if p.Min > 0 && size < p.Min {
+		return fmt.Errorf("size %g is below the minimum %g of %s", size, p.Min, p.ID)
+	}
+	if p.Max > 0 && size > p.Max {
+		return fmt.Errorf("size %g is above the maximum %g of %s", size, p.Max, p.ID)
+	}
+	return nil
+}
+
+// floor rounds a size down to the product's step, so an order never costs more than it was sized for.
+func (p *Product) floor(size float64) float64 {
+	if p.Step <= 0 {
+		return size
+	}
+	// the epsilon keeps a size that is a whole number of steps from flooring a step below it
+	return math.Floor(size/p.Step+1e-9) * p.Step
+}
+
+// balances maps each currency of the user's accounts to its available balance.
+func (u *User) balances() (map[string]float64, error) {
+	accounts, err := u.Client().GetAccounts()
+	if err != nil {
+		return nil, err
+	}
+	balances := map[string]float64{}
+	for _, account := range accounts {
+		balances[account.Currency] = util.StringToFloat64(account.Available)
+	}
+	return balances, nil
+}
+
+// balance is the available quote balance the pattern sizes entries from, zero when its sizing does not need one.
+func (p *Pattern) balance() (float64, error) {
+	if !p.needsBalance() {
+		return 0, nil
+	}
+	u := FindUserByID(p.UserID)
+	balances, err := u.balances()
+	return balances[p.Product.Quote], err
+}
+
+// simCash is the quote balance a simulation sizes entries from when the user has no paper cash.
+const simCash = 1000
+
+// simBalance is the quote balance a simulation of the pattern starts from, so a backtest never reads the live
+// account: the user's paper cash, or simCash without it.
+func (p *Pattern) simBalance() float64 {
+	if !p.needsBalance() {
+		return 0
+	}
+	if p.User.PaperCash > 0 {
+		return p.User.PaperCash
+	}
+	return simCash
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"nuchal-api/util"
+	"testing"
+	"time"
+)
+
+func TestSizing(t *testing.T) {
+
+	product := Product{Quote: "USD", Step: 0.0001}
+
+	cases := []struct {
+		sizing Sizing
+		size   float64
+		order  string
+		funds  string
+		base   float64
+	}{
+		{BaseSizing, 0.01, "0.0100", "", 0.01},
+		{QuoteSizing, 50, "", "50", 0.5},
+		{EquitySizing, 0.25, "", "250", 2.5},
+
+		// risking 1% of 1000 with a 5% stop at 100 buys 2
+		{RiskSizing, 0.01, "2.0000", "", 2},
+
+		// risking half of 1000 with a 5% stop would buy more than the balance
+		{RiskSizing, 0.5, "10.0000", "", 10},
+	}
+
+	for _, c := range cases {
+
+		p := Pattern{ProductID: productID, Product: product, Size: c.size, Sizing: c.sizing, Tolerance: 0.05}
+		if _, err := p.validateSizing(); err != nil {
+			t.Fail()
+		}
+
+		order, err := p.entryOrder(100, 1000)
+		if err != nil || order.Size != c.order || order.Funds != c.funds || order.Type != "market" || order.Side != "buy" {
+			util.PrettyPrint(order)
+			t.Fail()
+		}
+
+		if base := p.baseSize(100, 1000); base != c.base {
+			util.PrettyPrint(base)
+			t.Fail()
+		}
+	}
+
+	// the balance also pays the taker fee, and the size rounds down to the step
+	risky := Pattern{Product: product, User: User{Api: Api{Taker: 0.005}}, Size: 0.5, Sizing: RiskSizing, Tolerance: 0.05}
+	if order, _ := risky.entryOrder(100, 1000); order.Size != "9.9502" {
+		util.PrettyPrint(order)
+		t.Fail()
+	}
+
+	// every sizing is held to the product's minimum, funds by the size they buy at the price
+	small := Pattern{Product: Product{Quote: "USD", Step: 0.0001, Min: 1}, Size: 50, Sizing: QuoteSizing}
+	if _, err := small.entryOrder(100, 1000); err == nil {
+		t.Fail()
+	}
+
+	if balance := (&Pattern{Sizing: EquitySizing, User: User{Api: Api{PaperCash: 500}}}).simBalance(); balance != 500 {
+		t.Fail()
+	}
+
+	if balance := (&Pattern{Sizing: RiskSizing}).simBalance(); balance != simCash {
+		t.Fail()
+	}
+
+	invalid := []Pattern{
+		{Sizing: EquitySizing, Size: 2},
+		{Sizing: RiskSizing, Size: 0.01},
+		{Sizing: "lots", Size: 1},
+		{Size: 0},
+	}
+
+	for _, p := range invalid {
+		if _, err := p.validateSizing(); err == nil {
+			util.PrettyPrint(p.Sizing)
+			t.Fail()
+		}
+	}
+}
+
+func TestAwaitFill(t *testing.T) {
+
+	f := NewFakeExchange(0.005, 0.005)
+	f.Deposit("USD", 100)
+	f.SetPrice("ALGO-USD", 2)
+
+	entry, err := f.CreateOrder(&cb.Order{ProductID: "ALGO-USD", Side: "buy", Funds: "50", Type: "market"})
+	if err != nil {
+		t.Fail()
+	}
+
+	order, err := awaitFill(f, entry.ID, time.Second)
+	if err != nil || util.StringToFloat64(order.FilledSize) == 0 {
+		t.Fail()
+	}
+
+	resting, err := f.CreateOrder(&cb.Order{ProductID: "ALGO-USD", Side: "buy", Size: "1", Price: "1", Type: "limit"})
+	if err != nil {
+		t.Fail()
+	}
+
+	if _, err = awaitFill(f, resting.ID, time.Second); err == nil {
+		t.Fail()
+	}
+
+	util.PrettyPrint(order)
+}
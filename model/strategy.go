@@ -0,0 +1,146 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"nuchal-api/indicator"
+	"time"
+)
+
+// ExitStrategy names how a trade exits once its pattern has entered.
+type ExitStrategy string
+
+const (
+
+	// ClimbExit stops at the loss price, then after the goal re-anchors the stop to each higher close.
+	ClimbExit ExitStrategy = "climb"
+
+	// LimitExit takes profit with a limit at the goal price, stopping at the loss price.
+	LimitExit = "limit"
+
+	// TrailingExit stops a fraction Trail below the highest price since entry.
+	TrailingExit = "trailing"
+
+	// ATRExit stops Multiple ATRs of Period below the highest close since entry.
+	ATRExit = "atr"
+
+	// SteppedExit stops at the loss price, raising the stop a step each time the price climbs a fraction Step
+	// of the entry, one step behind the price.
+	SteppedExit = "stepped"
+
+	// TimeExit sells at market Hold minutes after entry, stopping at the loss price until then.
+	TimeExit = "time"
+)
+
+// Strategy is the exit strategy of a pattern and its parameters.
+type Strategy struct {
+	Exit     ExitStrategy `json:"exit" gorm:"default:climb"`
+	Trail    float64      `json:"trail"`
+	Multiple float64      `json:"multiple"`
+	Period   int          `json:"period"`
+	Step     float64      `json:"step"`
+	Hold     int64        `json:"hold"`
+}
+
+func (s Strategy) validate() error {
+	switch s.Exit {
+	case "", ClimbExit, LimitExit:
+	case TrailingExit:
+		if s.Trail <= 0 || s.Trail >= 1 {
+			return errors.New("trailing exit needs a trail between 0 and 1")
+		}
+	case ATRExit:
+		if s.Multiple <= 0 || s.Period < 1 {
+			return errors.New("atr exit needs a positive multiple and period")
+		}
+	case SteppedExit:
+		if s.Step <= 0 {
+			return errors.New("stepped exit needs a positive step")
+		}
+	case TimeExit:
+		if s.Hold < 1 {
+			return errors.New("time exit needs a hold of at least a minute")
+		}
+	default:
+		return fmt.Errorf("unknown exit strategy %q", s.Exit)
+	}
+	return nil
+}
+
+// trails reports whether the strategy is played out by an exit rather than the original climb.
+func (s Strategy) trails() bool {
+	return s.Exit != "" && s.Exit != ClimbExit
+}
+
+// exit is one trade played out by an exit strategy, rate by closed rate, the same in a sell session and a sim.
+type exit struct {
+	Strategy
+	entry, goal float64
+	since       time.Time
+	granularity Granularity
+
+	// stop is the price to sell at should the price fall to it.
+	stop float64
+
+	high  float64
+	atr   *indicator.ATRStream
+	steps int
+}
+
+// newExit starts an exit at entry, priming its indicators with the rates before entry.
+func newExit(s Strategy, entry, goal, loss float64, since time.Time, granularity Granularity, history []Rate) *exit {
+	e := &exit{Strategy: s, entry: entry, goal: goal, since: since, granularity: granularity, stop: loss, high: entry}
+	if s.Exit == ATRExit {
+		e.atr = indicator.NewATRStream(s.Period)
+		for _, rate := range history {
+			e.atr.Next(rate.Candle())
+		}
+	}
+	return e
+}
+
+// limit is the take profit price, zero when the strategy has none.
+func (e *exit) limit() float64 {
+	if e.Exit == LimitExit {
+		return e.goal
+	}
+	return 0
+}
+
+// next plays a rate that just closed, raising the stop, and reports whether to sell at its close.
+func (e *exit) next(rate Rate) bool {
+
+	switch e.Exit {
+	case TrailingExit:
+		e.high = math.Max(e.high, rate.High)
+		e.raise(e.high * (1 - e.Trail))
+
+	case ATRExit:
+		e.high = math.Max(e.high, rate.Close)
+		if atr, ok := e.atr.Next(rate.Candle()); ok {
+			e.raise(e.high - e.Multiple*atr)
+		}
+
+	case SteppedExit:
+		for rate.High >= e.entry*(1+float64(e.steps+1)*e.Step) {
+			e.steps++
+		}
+		if e.steps > 0 {
+			e.raise(e.entry * (1 + float64(e.steps-1)*e.Step))
+		}
+
+	case TimeExit:
+		closed := rate.Time().Add(e.granularity.Duration())
+		return closed.Sub(e.since) >= time.Duration(e.Hold)*time.Minute
+	}
+
+	// a stop raised to the close would only be hit at once
+	return e.stop >= rate.Close
+}
+
+func (e *exit) raise(stop float64) {
+	if stop > e.stop {
+		e.stop = stop
+	}
+}
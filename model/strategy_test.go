@@ -0,0 +1,56 @@
+package model
+
+import (
+	"math"
+	"nuchal-api/util"
+	"testing"
+	"time"
+)
+
+func TestExitStrategies(t *testing.T) {
+
+	// rises from 100 to 110, then falls back to 97
+	var rates []Rate
+	for i, c := range []float64{100, 102, 104, 106, 108, 110, 109, 108, 107, 106, 105, 104, 103, 102, 101, 100, 99, 98, 97} {
+		rates = append(rates, Rate{UnixSecond: int64(i * 60), Open: c, High: c + 1, Low: c - 1, Close: c, Volume: 1})
+	}
+
+	cases := []struct {
+		strategy Strategy
+		trade    TradeType
+		out      float64
+	}{
+		{Strategy{Exit: LimitExit}, goalType, 107.1},
+		{Strategy{Exit: TrailingExit, Trail: 0.05}, stopType, 105.45},
+		{Strategy{Exit: SteppedExit, Step: 0.03}, stopType, 105.06},
+		{Strategy{Exit: ATRExit, Multiple: 1.5, Period: 3}, stopType, 106.4},
+		{Strategy{Exit: TimeExit, Hold: 3}, timeType, 106},
+	}
+
+	for _, c := range cases {
+
+		p := Pattern{Size: 1, Target: 0.05, Tolerance: 0.1, Strategy: c.strategy, Product: Product{Step: 0.01}}
+		if p.validate(testProduct(), testUser()) != nil {
+			t.Fail()
+		}
+
+		trade := newTrade(1, p)
+		trade.em(rates, 1, Minute)
+
+		if trade.Type != c.trade || math.Abs(trade.out()-c.out) > 0.01 {
+			util.PrettyPrint(c.strategy)
+			util.PrettyPrint(trade)
+			util.PrettyPrint(trade.out())
+			t.Fail()
+		}
+	}
+
+	if (&Pattern{Size: 1, Strategy: Strategy{Exit: TrailingExit}}).validate(testProduct(), testUser()) == nil {
+		t.Fail()
+	}
+
+	e := newExit(Strategy{Exit: SteppedExit, Step: 0.1}, 100, 0, 90, time.Unix(0, 0), Minute, nil)
+	if e.next(Rate{High: 125, Close: 122}) || math.Abs(e.stop-110) > 1e-9 {
+		t.Fail()
+	}
+}
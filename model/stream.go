@@ -0,0 +1,69 @@
+package model
+
+// StreamMessage is a live chart update of a product. A tick carries the open candle including the tick,
+// a candle carries a closed minute, both as the data of a Rate.
+type StreamMessage struct {
+	Type      string        `json:"type"`
+	ProductID string        `json:"product_id"`
+	Price     float64       `json:"price,omitempty"`
+	Data      []interface{} `json:"data"`
+}
+
+const (
+	tickMessage   = "tick"
+	candleMessage = "candle"
+)
+
+// Stream sends the live ticks and closed candles of a product until done is closed, send fails, or the feed is lost.
+func Stream(productID string, send func(StreamMessage) error, done <-chan struct{}) error {
+
+	sub, err := HubFor(environment.Feed).Subscribe(productID)
+	if err != nil {
+		return err
+	}
+
+	return stream(sub, send, done)
+}
+
+// stream sends the ticks and closed candles of a Subscription, closing it once done is closed, send fails,
+// or the Subscription is lost.
+func stream(sub *Subscription, send func(StreamMessage) error, done <-chan struct{}) error {
+
+	defer func() {
+		if err := sub.Close(); err != nil {
+			sub.hub.log().Err(err).Send()
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-sub.Lost:
+			// send the candles that closed before it was lost, e.g. the last of a replay
+			for {
+				select {
+				case rate := <-sub.Rates:
+					if err := send(StreamMessage{candleMessage, sub.ProductID, 0, rate.data()}); err != nil {
+						return err
+					}
+					continue
+				default:
+				}
+				return errPipeLost
+			}
+		case tick := <-sub.Ticks:
+			rate, ok := sub.hub.open(sub.ProductID)
+			if !ok {
+				continue
+			}
+			if err := send(StreamMessage{tickMessage, sub.ProductID, tick.Price, rate.data()}); err != nil {
+				return err
+			}
+		case rate := <-sub.Rates:
+			if err := send(StreamMessage{candleMessage, sub.ProductID, 0, rate.data()}); err != nil {
+				return err
+			}
+		}
+	}
+}
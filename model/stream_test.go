@@ -0,0 +1,30 @@
+package model
+
+import (
+	"nuchal-api/util"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+
+	done := make(chan struct{})
+
+	var messages []StreamMessage
+	send := func(msg StreamMessage) error {
+		messages = append(messages, msg)
+		if len(messages) == 2 {
+			close(done)
+		}
+		return nil
+	}
+
+	if err := Stream(productID, send, done); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) == 0 || messages[0].Type != tickMessage || len(messages[0].Data) != 6 {
+		t.Fail()
+	}
+
+	util.PrettyPrint(messages)
+}
@@ -1,11 +1,8 @@
 package model
 
 import (
-	cb "github.com/preichenberger/go-coinbasepro/v2"
 	"gorm.io/gorm"
-	"net/http"
 	"nuchal-api/db"
-	"time"
 )
 
 type User struct {
@@ -22,19 +19,40 @@ type Api struct {
 	Secret string  `json:"secret"`
 	Maker  float64 `json:"maker"`
 	Taker  float64 `json:"taker"`
+
+	// Venue names the Exchange this Api trades on, Coinbase Pro when empty.
+	Venue string `json:"venue"`
+
+	// Paper routes orders to a simulated matching engine instead of the Venue.
+	Paper bool `json:"paper"`
+
+	// PaperCash is the USD balance a paper trading ledger starts with.
+	PaperCash float64 `json:"paper_cash"`
+
+	// Sandbox points the Api at the exchange sandbox instead of production.
+	Sandbox bool `json:"sandbox"`
+
+	// Rest overrides the REST base URL, e.g. a local stand-in server.
+	Rest string `json:"rest"`
+
+	// Feed overrides the websocket feed URL.
+	Feed string `json:"feed"`
 }
 
-func (v *Api) Client() *cb.Client {
-	return &cb.Client{
-		"https://api.pro.coinbase.com",
-		v.Secret,
-		v.Key,
-		v.Pass,
-		&http.Client{
-			Timeout: 15 * time.Second,
-		},
-		0,
+// Client returns the Exchange registered for the Api Venue, one that fails every call when the Venue is unknown.
+func (v *Api) Client() Exchange {
+	if newExchange, ok := exchangeFor(v.Venue); ok {
+		return newExchange(v)
 	}
+	return newUnknownExchange(v.Venue)
+}
+
+// Client returns the paper trading engine when the User trades on paper, otherwise the Api Exchange.
+func (u *User) Client() Exchange {
+	if u.Paper {
+		return paperFor(u)
+	}
+	return u.Api.Client()
 }
 
 func init() {
@@ -59,13 +77,17 @@ func FindUsers() []User {
 	return users
 }
 
-func SaveUser(user User) User {
+// SaveUser saves a user, refusing a Venue no Exchange is registered for.
+func SaveUser(user User) (User, error) {
+	if _, ok := exchangeFor(user.Venue); !ok {
+		return user, &FieldError{Field: "venue", Message: "unknown venue " + user.Venue}
+	}
 	if user.ID > 0 {
 		db.Resolve().Save(&user)
 	} else {
 		db.Resolve().Create(&user)
 	}
-	return user
+	return user, nil
 }
 
 func DeleteUser(ID uint) {
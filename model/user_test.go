@@ -23,3 +23,18 @@ func TestFindUserByID(t *testing.T) {
 //	fmt.Println(util.Pretty(savedUser))
 //
 //}
+
+func TestApiClient(t *testing.T) {
+
+	if _, err := (&Api{Venue: "fkae"}).Client().GetAccounts(); err == nil {
+		t.Fail()
+	}
+
+	if _, ok := (&Api{Venue: fakeVenue}).Client().(*FakeExchange); !ok {
+		t.Fail()
+	}
+
+	if _, ok := (&Api{}).Client().(*coinbase); !ok {
+		t.Fail()
+	}
+}
@@ -0,0 +1,108 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FieldError is a field of a pattern that is invalid, and for a rule the 1-based position of the error in it.
+type FieldError struct {
+	Field    string `json:"field"`
+	Message  string `json:"error"`
+	Position int    `json:"position,omitempty"`
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationError is every invalid field of a pattern.
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	var messages []string
+	for _, f := range e.Fields {
+		messages = append(messages, fmt.Sprintf("%s: %s", f.Field, f.Message))
+	}
+	return "invalid pattern: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field string, err error) {
+	f := FieldError{Field: field, Message: err.Error()}
+	var ruleErr *RuleError
+	if errors.As(err, &ruleErr) {
+		f.Message, f.Position = ruleErr.Message, ruleErr.Position
+	}
+	e.Fields = append(e.Fields, f)
+}
+
+// Validate checks the pattern against its product and the fees of its user before it is saved or started.
+func (p *Pattern) Validate() error {
+	product, err := FindProductByID(p.ProductID)
+	if err != nil {
+		return err
+	}
+	return p.validate(product, FindUserByID(p.UserID))
+}
+
+// validate checks the pattern given its product and user, either of which is unknown when it has no ID.
+func (p *Pattern) validate(product Product, user User) error {
+
+	var e ValidationError
+
+	if user.ID == 0 {
+		e.add("user_id", fmt.Errorf("unknown user %d", p.UserID))
+	}
+
+	if product.ID == "" {
+		e.add("product_id", fmt.Errorf("unknown product %q", p.ProductID))
+	}
+
+	if field, err := p.validateSizing(); err != nil {
+		e.add(field, err)
+	} else if product.ID != "" && (p.Sizing == "" || p.Sizing == BaseSizing) {
+		// other sizings are checked against the product as each entry is sized
+		if err = product.checkSize(p.Size); err != nil {
+			e.add("size", err)
+		}
+	}
+
+	if p.Tolerance < 0 || p.Tolerance >= 1 {
+		e.add("tolerance", errors.New("tolerance must be at least 0 and below 1"))
+	}
+
+	// the target has to clear the fees of buying and selling to gain anything
+	if fees := (1+user.Maker)*(1+user.Taker) - 1; p.Target <= fees {
+		e.add("target", fmt.Errorf("target %g does not clear the round trip fees of %g", p.Target, fees))
+	}
+
+	if _, ok := p.detector(); !ok {
+		e.add("formation", fmt.Errorf("unknown formation %q", p.Formation))
+	}
+
+	if err := p.Strategy.validate(); err != nil {
+		e.add("strategy", err)
+	}
+
+	for i := range p.Filters {
+		if err := p.Filters[i].validate(); err != nil {
+			e.add(fmt.Sprintf("filters[%d]", i), err)
+		}
+	}
+
+	for _, rule := range [][2]string{{entryRule, p.Entry}, {exitRule, p.Exit}} {
+		if strings.TrimSpace(rule[1]) != "" {
+			if _, err := compileRule(rule[0], rule[1]); err != nil {
+				e.add(rule[0], err)
+			}
+		}
+	}
+
+	if len(e.Fields) > 0 {
+		return &e
+	}
+	return nil
+}
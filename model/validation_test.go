@@ -0,0 +1,68 @@
+package model
+
+import (
+	"nuchal-api/util"
+	"testing"
+)
+
+func testProduct() Product {
+	var product Product
+	product.ID = productID
+	product.Quote = "USD"
+	product.Min = 0.1
+	product.Max = 1000
+	product.Step = 0.01
+	return product
+}
+
+func testUser() User {
+	var user User
+	user.ID = userID
+	user.Maker = 0.004
+	user.Taker = 0.006
+	return user
+}
+
+func TestValidation(t *testing.T) {
+
+	p := &Pattern{ProductID: productID, Size: 1, Target: 0.02, Tolerance: 0.01}
+	if err := p.validate(testProduct(), testUser()); err != nil {
+		util.PrettyPrint(err)
+		t.Fail()
+	}
+
+	p = &Pattern{ProductID: "NOPE-USD", Size: 0.01, Target: 0.01, Tolerance: -0.1, Formation: "doji", Entry: "close <"}
+	err, ok := p.validate(Product{}, User{}).(*ValidationError)
+	if !ok {
+		t.FailNow()
+	}
+
+	util.PrettyPrint(err)
+
+	fields := map[string]FieldError{}
+	for _, f := range err.Fields {
+		fields[f.Field] = f
+	}
+
+	for _, field := range []string{"user_id", "product_id", "tolerance", "formation", "entry"} {
+		if _, ok := fields[field]; !ok {
+			util.PrettyPrint(field)
+			t.Fail()
+		}
+	}
+
+	if fields["entry"].Position != 8 {
+		t.Fail()
+	}
+
+	// below the product minimum, and a target inside the fees
+	err, ok = (&Pattern{ProductID: productID, Size: 0.01, Target: 0.01}).validate(testProduct(), testUser()).(*ValidationError)
+	if !ok || len(err.Fields) != 2 || err.Fields[0].Field != "size" || err.Fields[1].Field != "target" {
+		t.Fail()
+	}
+
+	// sized in the quote currency, the base minimum does not apply
+	if (&Pattern{ProductID: productID, Size: 0.01, Sizing: QuoteSizing, Target: 0.02}).validate(testProduct(), testUser()) != nil {
+		t.Fail()
+	}
+}
@@ -15,13 +15,28 @@ const (
 	InitAllCBProducts JobType = "init all cb products"
 	InitOneDayOfRates         = "init one day of rates"
 	SellAllOfAProduct         = "sell all of a product"
+	RefreshRates              = "refresh rates"
+)
+
+type JobStatus string
+
+const (
+	jobRunning JobStatus = "running"
+	jobDone              = "done"
+	jobFailed            = "failed"
 )
 
 type Job struct {
 	gorm.Model
-	UserID  uint    `gorm:"user_id"`
-	JobType JobType `gorm:"job_type"`
-	Message string  `json:"message"`
+	UserID   uint          `gorm:"user_id"`
+	JobType  JobType       `gorm:"job_type"`
+	Message  string        `json:"message"`
+	Status   JobStatus     `json:"status"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error"`
+
+	// fetched is whether a refresh found missing rates and fetched them.
+	fetched bool
 }
 
 func init() {
@@ -36,9 +51,7 @@ func PerformAllJobs(userID uint) error {
 		JobType: InitAllCBProducts,
 	}
 
-	db.Resolve().Create(&productJob)
-
-	if err := productJob.Perform(); err != nil {
+	if err := productJob.run(); err != nil {
 		log.Error().Err(err).Stack().Send()
 		return err
 	}
@@ -49,9 +62,7 @@ func PerformAllJobs(userID uint) error {
 		JobType: InitOneDayOfRates,
 	}
 
-	db.Resolve().Create(&ratesJob)
-
-	if err := ratesJob.Perform(); err != nil {
+	if err := ratesJob.run(); err != nil {
 		log.Error().Err(err).Stack().Send()
 		return err
 	}
@@ -59,12 +70,44 @@ func PerformAllJobs(userID uint) error {
 	return nil
 }
 
+// run records the job as running, performs it, then records its status, duration and any error.
+func (j *Job) run() error {
+
+	j.Status = jobRunning
+	db.Resolve().Create(j)
+
+	err := j.perform()
+
+	db.Resolve().Save(j)
+
+	return err
+}
+
+// perform performs the job, setting its status, duration and any error without recording it.
+func (j *Job) perform() error {
+
+	start := time.Now()
+	err := j.Perform()
+
+	j.Duration = time.Since(start)
+	if err != nil {
+		j.Status = jobFailed
+		j.Error = err.Error()
+	} else {
+		j.Status = jobDone
+	}
+
+	return err
+}
+
 func (j *Job) Perform() error {
 	switch j.JobType {
 	case InitAllCBProducts:
 		return j.initAllCBProducts()
 	case InitOneDayOfRates:
 		return j.initOneDayOfRates()
+	case RefreshRates:
+		return j.refreshRates()
 	}
 	return nil
 }
@@ -76,7 +119,7 @@ func (j *Job) initOneDayOfRates() error {
 		for _, product := range products {
 			omega := time.Now()
 			alpha := omega.Add(time.Hour * -24)
-			if _, err := GetRates(j.UserID, product.ID, alpha.Unix(), omega.Unix()); err != nil {
+			if _, err := GetRates(product.ID, alpha.Unix(), omega.Unix(), Minute); err != nil {
 				log.Error().Err(err).Stack().Send()
 			}
 		}
@@ -94,13 +137,13 @@ func (j *Job) initAllCBProducts() error {
 	var cbProducts []cb.Product
 	var err error
 
-	u := FindUserByID(j.UserID)
+	market := marketData()
 
-	if cbCurrencies, err = u.Client().GetCurrencies(); err != nil {
+	if cbCurrencies, err = market.GetCurrencies(); err != nil {
 		return err
 	}
 
-	if cbProducts, err = u.Client().GetProducts(); err != nil {
+	if cbProducts, err = market.GetProducts(); err != nil {
 		return err
 	}
 
@@ -134,10 +177,12 @@ func (j *Job) isTimeToDoJob() bool {
 
 	db.Resolve().
 		Where("job_type = ?", string(j.JobType)).
-		Order("omega desc").
+		Where("status = ?", jobDone).
+		Where("id <> ?", j.ID).
+		Order("updated_at desc").
 		First(&lastJob)
 
-	timeToDoJob := lastJob.ID == uint(0) || lastJob.UpdatedAt.After(time.Now().Add(time.Hour*24))
+	timeToDoJob := lastJob.ID == uint(0) || lastJob.UpdatedAt.Before(time.Now().Add(time.Hour*-24))
 
 	log.Trace().
 		Str("job type", string(j.JobType)).
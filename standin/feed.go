@@ -0,0 +1,269 @@
+package standin
+
+import (
+	"github.com/gin-gonic/gin"
+	ws "github.com/gorilla/websocket"
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bookSize is the size of each level of the synthetic order books.
+const bookSize = "1000"
+
+var upgrader = ws.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// subscriber is a websocket connection and the products it subscribed to per channel.
+type subscriber struct {
+	mu       sync.Mutex
+	conn     *ws.Conn
+	channels map[string]map[string]bool
+}
+
+func (s *subscriber) write(msg interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.WriteJSON(msg)
+}
+
+func (s *subscriber) wants(channel, productID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.channels[channel][productID]
+}
+
+func (s *subscriber) products(channel string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for productID, ok := range s.channels[channel] {
+		if ok {
+			ids = append(ids, productID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// feed upgrades the request and handles subscribe and unsubscribe messages until the client disconnects.
+func (s *Server) feed(c *gin.Context) {
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Err(err).Msg("upgrading feed")
+		return
+	}
+
+	sub := &subscriber{conn: conn, channels: map[string]map[string]bool{
+		"ticker":    {},
+		"level2":    {},
+		"heartbeat": {},
+	}}
+
+	s.mu.Lock()
+	s.subscribers[sub] = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go s.heartbeat(sub, done)
+
+	defer func() {
+		close(done)
+		s.mu.Lock()
+		delete(s.subscribers, sub)
+		s.mu.Unlock()
+		if err := conn.Close(); err != nil {
+			log.Err(err).Msg("closing feed")
+		}
+	}()
+
+	for {
+
+		var msg cb.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		var snapshots []string
+
+		sub.mu.Lock()
+		for _, channel := range msg.Channels {
+			if _, ok := sub.channels[channel.Name]; !ok {
+				continue
+			}
+			for _, productID := range channel.ProductIds {
+				if channel.Name == "level2" && msg.Type == "subscribe" && !sub.channels[channel.Name][productID] {
+					snapshots = append(snapshots, productID)
+				}
+				sub.channels[channel.Name][productID] = msg.Type == "subscribe"
+			}
+		}
+		sub.mu.Unlock()
+
+		if err := sub.write(cb.Message{
+			Type: "subscriptions",
+			Channels: []cb.MessageChannel{
+				{Name: "ticker", ProductIds: sub.products("ticker")},
+				{Name: "level2", ProductIds: sub.products("level2")},
+				{Name: "heartbeat", ProductIds: sub.products("heartbeat")},
+			},
+		}); err != nil {
+			return
+		}
+
+		for _, productID := range snapshots {
+			if err := sub.write(s.snapshot(productID)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// level2 is a level2 channel message, whose levels and changes are arrays of strings on the wire.
+type level2 struct {
+	Type      string     `json:"type"`
+	ProductID string     `json:"product_id"`
+	Time      string     `json:"time,omitempty"`
+	Bids      [][]string `json:"bids,omitempty"`
+	Asks      [][]string `json:"asks,omitempty"`
+	Changes   [][]string `json:"changes,omitempty"`
+}
+
+// snapshot is a level2 snapshot of a product's book, a single level on each side at the exchange price.
+func (s *Server) snapshot(productID string) level2 {
+	price := strconv.FormatFloat(s.exchange.Price(productID), 'f', -1, 64)
+	return level2{
+		Type:      "snapshot",
+		ProductID: productID,
+		Bids:      [][]string{{price, bookSize}},
+		Asks:      [][]string{{price, bookSize}},
+	}
+}
+
+// l2update moves the single level on each side of a product's book from one price to another.
+func l2update(productID string, from, to float64) level2 {
+	was := strconv.FormatFloat(from, 'f', -1, 64)
+	now := strconv.FormatFloat(to, 'f', -1, 64)
+	return level2{
+		Type:      "l2update",
+		ProductID: productID,
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Changes: [][]string{
+			{"buy", was, "0"},
+			{"sell", was, "0"},
+			{"buy", now, bookSize},
+			{"sell", now, bookSize},
+		},
+	}
+}
+
+// heartbeat writes a heartbeat message per subscribed product every second until done.
+func (s *Server) heartbeat(sub *subscriber, done chan struct{}) {
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			for _, productID := range sub.products("heartbeat") {
+				s.mu.Lock()
+				sequence := s.sequence
+				s.mu.Unlock()
+				if err := sub.write(cb.Message{
+					Type:      "heartbeat",
+					ProductID: productID,
+					Sequence:  sequence,
+					Time:      cb.Time(now.UTC()),
+				}); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Replay walks every loaded candle in time order, moving the exchange price through each candle's
+// open, low, high and close and publishing a ticker message per step, one step every interval.
+func (s *Server) Replay(interval time.Duration) {
+
+	type step struct {
+		productID string
+		at        time.Time
+		price     float64
+		size      float64
+	}
+
+	var steps []step
+
+	s.mu.Lock()
+	for productID, rates := range s.candles {
+		for _, rate := range rates {
+			size := rate.Volume / 4
+			for i, price := range []float64{rate.Open, rate.Low, rate.High, rate.Close} {
+				at := rate.Time.Add(time.Duration(i) * 15 * time.Second)
+				steps = append(steps, step{productID, at, price, size})
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].at.Before(steps[j].at)
+	})
+
+	for _, st := range steps {
+		was := s.exchange.Price(st.productID)
+		s.exchange.SetPrice(st.productID, st.price)
+		s.publish(st.productID, was, st.price, st.size)
+		time.Sleep(interval)
+	}
+}
+
+func (s *Server) publish(productID string, was, price, size float64) {
+
+	update := l2update(productID, was, price)
+
+	s.mu.Lock()
+	s.sequence++
+	msg := cb.Message{
+		Type:      "ticker",
+		ProductID: productID,
+		Sequence:  s.sequence,
+		Time:      cb.Time(time.Now().UTC()),
+		Price:     strconv.FormatFloat(price, 'f', -1, 64),
+		BestBid:   strconv.FormatFloat(price, 'f', -1, 64),
+		BestAsk:   strconv.FormatFloat(price, 'f', -1, 64),
+		LastSize:  strconv.FormatFloat(size, 'f', -1, 64),
+		Side:      "buy",
+	}
+	var subs []*subscriber
+	for sub := range s.subscribers {
+		subs = append(subs, sub)
+	}
+	s.mu.Unlock()
+
+	for _, sub := range subs {
+		if sub.wants("level2", productID) && was != price {
+			if err := sub.write(update); err != nil {
+				log.Err(err).Str("productID", productID).Msg("writing feed")
+			}
+		}
+		if !sub.wants("ticker", productID) {
+			continue
+		}
+		if err := sub.write(msg); err != nil {
+			log.Err(err).Str("productID", productID).Msg("writing feed")
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package standin
+
+import (
+	"encoding/csv"
+	"errors"
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"io"
+	"nuchal-api/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FromRates reads the stored Rate rows of a product between alpha and omega.
+func FromRates(productID string, alpha, omega int64) []cb.HistoricRate {
+	var rates []cb.HistoricRate
+	for _, rate := range model.FindRates(productID, alpha, omega, model.Minute) {
+		rates = append(rates, cb.HistoricRate{
+			Time:   rate.Time().UTC(),
+			Low:    rate.Low,
+			High:   rate.High,
+			Open:   rate.Open,
+			Close:  rate.Close,
+			Volume: rate.Volume,
+		})
+	}
+	return rates
+}
+
+// FromCSV reads candles keyed by product from rows of product_id,unix_second,low,high,open,close,volume.
+// A header row is skipped.
+func FromCSV(r io.Reader) (map[string][]cb.HistoricRate, error) {
+
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string][]cb.HistoricRate{}
+
+	for i, row := range rows {
+
+		if len(row) != 7 {
+			return nil, errors.New("expected 7 columns on row " + strconv.Itoa(i+1))
+		}
+
+		var values [6]float64
+		for j := range values {
+			if values[j], err = strconv.ParseFloat(strings.TrimSpace(row[j+1]), 64); err != nil {
+				break
+			}
+		}
+
+		if err != nil {
+			if i == 0 {
+				err = nil
+				continue
+			}
+			return nil, err
+		}
+
+		productID := strings.TrimSpace(row[0])
+		result[productID] = append(result[productID], cb.HistoricRate{
+			Time:   time.Unix(int64(values[0]), 0).UTC(),
+			Low:    values[1],
+			High:   values[2],
+			Open:   values[3],
+			Close:  values[4],
+			Volume: values[5],
+		})
+	}
+
+	return result, nil
+}
+
+// NewProduct describes a product for Load, using stored constraints when the product is known.
+func NewProduct(productID string) cb.Product {
+
+	sides := strings.Split(productID, "-")
+	product := cb.Product{
+		ID:             productID,
+		BaseCurrency:   sides[0],
+		QuoteCurrency:  sides[len(sides)-1],
+		BaseMinSize:    "0.00000001",
+		BaseMaxSize:    "1000000",
+		QuoteIncrement: "0.01",
+	}
+
+	if p, err := model.FindProductByID(productID); err == nil && p.ID != "" {
+		product.BaseMinSize = strconv.FormatFloat(p.Min, 'f', -1, 64)
+		product.BaseMaxSize = strconv.FormatFloat(p.Max, 'f', -1, 64)
+		product.QuoteIncrement = strconv.FormatFloat(p.Step, 'f', -1, 64)
+	}
+
+	return product
+}
@@ -0,0 +1,241 @@
+// Package standin is a local stand-in for the subset of Coinbase Pro this project uses.
+// It serves the REST endpoints for orders, fills, accounts, products, currencies and candles,
+// and the websocket ticker channel, replaying stored prices through a model.FakeExchange.
+package standin
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"io/ioutil"
+	"net/http"
+	"nuchal-api/model"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Server struct {
+	exchange *model.FakeExchange
+
+	mu          sync.Mutex
+	candles     map[string][]cb.HistoricRate
+	subscribers map[*subscriber]bool
+	sequence    int64
+}
+
+func New(exchange *model.FakeExchange) *Server {
+	return &Server{
+		exchange:    exchange,
+		candles:     map[string][]cb.HistoricRate{},
+		subscribers: map[*subscriber]bool{},
+	}
+}
+
+// Load makes a product tradable and queues its candles for replay.
+func (s *Server) Load(product cb.Product, rates []cb.HistoricRate) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sort.SliceStable(rates, func(i, j int) bool {
+		return rates[i].Time.Before(rates[j].Time)
+	})
+
+	s.candles[product.ID] = append(s.candles[product.ID], rates...)
+
+	s.exchange.AddProduct(product,
+		cb.Currency{ID: product.BaseCurrency, Name: product.BaseCurrency, MinSize: product.BaseMinSize},
+		cb.Currency{ID: product.QuoteCurrency, Name: product.QuoteCurrency, MinSize: product.QuoteIncrement})
+
+	if len(rates) > 0 {
+		s.exchange.SetPrice(product.ID, rates[0].Open)
+	}
+}
+
+// Handler routes the REST endpoints and the websocket feed, which is served at the root like ws-feed.pro.coinbase.com.
+func (s *Server) Handler() http.Handler {
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+
+	router.GET("/", s.feed)
+
+	router.GET("/accounts", s.getAccounts)
+	router.GET("/currencies", s.getCurrencies)
+	router.GET("/products", s.getProducts)
+	router.GET("/products/:productID/candles", s.getCandles)
+
+	router.GET("/fills", s.getFills)
+
+	router.POST("/orders", s.postOrder)
+	router.GET("/orders", s.getOrders)
+	router.GET("/orders/:orderID", s.getOrder)
+	router.DELETE("/orders", s.deleteOrders)
+	router.DELETE("/orders/:orderID", s.deleteOrder)
+
+	return router
+}
+
+func (s *Server) getAccounts(c *gin.Context) {
+	accounts, err := s.exchange.GetAccounts()
+	respond(c, accounts, err)
+}
+
+func (s *Server) getCurrencies(c *gin.Context) {
+	currencies, err := s.exchange.GetCurrencies()
+	respond(c, currencies, err)
+}
+
+func (s *Server) getProducts(c *gin.Context) {
+	products, err := s.exchange.GetProducts()
+	respond(c, products, err)
+}
+
+// getCandles serves the candles loaded for a product in the [time, low, high, open, close, volume] wire format,
+// combining the loaded minute candles into candles of the requested granularity.
+func (s *Server) getCandles(c *gin.Context) {
+
+	productID := c.Param("productID")
+
+	granularity := model.Minute
+	if v := c.Query("granularity"); v != "" {
+		var err error
+		if granularity, err = model.ParseGranularity(v); err != nil {
+			c.JSON(http.StatusBadRequest, cb.Error{Message: "Unsupported granularity"})
+			return
+		}
+	}
+
+	var start, end time.Time
+	if v := c.Query("start"); v != "" {
+		start, _ = time.Parse(time.RFC3339, v)
+	}
+	if v := c.Query("end"); v != "" {
+		end, _ = time.Parse(time.RFC3339, v)
+	}
+
+	s.mu.Lock()
+	rates := aggregate(s.candles[productID], granularity)
+	s.mu.Unlock()
+
+	data := [][]interface{}{}
+	for i := len(rates) - 1; i >= 0; i-- {
+		rate := rates[i]
+		if !start.IsZero() && rate.Time.Before(start) {
+			continue
+		}
+		if !end.IsZero() && rate.Time.After(end) {
+			continue
+		}
+		data = append(data, []interface{}{rate.Time.Unix(), rate.Low, rate.High, rate.Open, rate.Close, rate.Volume})
+		if len(data) == 300 {
+			break
+		}
+	}
+
+	c.JSON(http.StatusOK, data)
+}
+
+// aggregate combines time ordered minute candles into candles of the granularity, aligned to UTC midnight.
+func aggregate(rates []cb.HistoricRate, granularity model.Granularity) []cb.HistoricRate {
+
+	if granularity == model.Minute {
+		return rates
+	}
+
+	var results []cb.HistoricRate
+
+	for _, rate := range rates {
+
+		bucket := rate.Time.UTC().Truncate(granularity.Duration())
+
+		if n := len(results); n > 0 && results[n-1].Time.Equal(bucket) {
+			r := &results[n-1]
+			r.Close = rate.Close
+			r.Volume += rate.Volume
+			if rate.High > r.High {
+				r.High = rate.High
+			}
+			if rate.Low < r.Low {
+				r.Low = rate.Low
+			}
+			continue
+		}
+
+		rate.Time = bucket
+		results = append(results, rate)
+	}
+
+	return results
+}
+
+func (s *Server) getFills(c *gin.Context) {
+	fills, err := s.exchange.ListFills(c.Query("product_id"))
+	if fills == nil {
+		fills = []cb.Fill{}
+	}
+	respond(c, fills, err)
+}
+
+func (s *Server) postOrder(c *gin.Context) {
+
+	data, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		respond(c, nil, err)
+		return
+	}
+
+	var order cb.Order
+	if err = json.Unmarshal(data, &order); err != nil {
+		respond(c, nil, err)
+		return
+	}
+
+	order, err = s.exchange.CreateOrder(&order)
+	respond(c, order, err)
+}
+
+func (s *Server) getOrders(c *gin.Context) {
+	orders, err := s.exchange.ListOrders(c.Query("product_id"))
+	if orders == nil {
+		orders = []cb.Order{}
+	}
+	respond(c, orders, err)
+}
+
+func (s *Server) getOrder(c *gin.Context) {
+	order, err := s.exchange.GetOrder(c.Param("orderID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, cb.Error{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+func (s *Server) deleteOrders(c *gin.Context) {
+	ids, err := s.exchange.CancelAllOrders(c.Query("product_id"))
+	if ids == nil {
+		ids = []string{}
+	}
+	respond(c, ids, err)
+}
+
+func (s *Server) deleteOrder(c *gin.Context) {
+	err := s.exchange.CancelOrder(c.Param("orderID"))
+	respond(c, c.Param("orderID"), err)
+}
+
+// respond writes v, or err in the Coinbase Pro error format.
+func respond(c *gin.Context, v interface{}, err error) {
+	if err != nil {
+		status := http.StatusBadRequest
+		if strings.Contains(strings.ToLower(err.Error()), "not found") {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, cb.Error{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, v)
+}
@@ -0,0 +1,65 @@
+package standin
+
+import (
+	cb "github.com/preichenberger/go-coinbasepro/v2"
+	"net/http/httptest"
+	"nuchal-api/model"
+	"nuchal-api/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+
+	exchange := model.NewFakeExchange(0.005, 0.005)
+	exchange.Deposit("USD", 1000)
+
+	rates, err := FromCSV(strings.NewReader("product_id,unix_second,low,high,open,close,volume\n" +
+		"ALGO-USD,1633046400,0.9,1.1,1,1.05,100\n" +
+		"ALGO-USD,1633046460,1,1.2,1.05,1.1,100\n"))
+	if err != nil {
+		t.Fail()
+	}
+
+	server := New(exchange)
+	server.Load(NewProduct("ALGO-USD"), rates["ALGO-USD"])
+
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	client := &cb.Client{BaseURL: ts.URL, HTTPClient: ts.Client()}
+
+	order, err := client.CreateOrder(&cb.Order{ProductID: "ALGO-USD", Side: "buy", Size: "10", Type: "market"})
+	if err != nil || order.Status != "done" {
+		t.Fail()
+	}
+
+	candles, err := client.GetHistoricRates("ALGO-USD", cb.GetHistoricRatesParams{
+		Start: time.Unix(1633046400, 0),
+		End:   time.Unix(1633046460, 0),
+	})
+	if err != nil || len(candles) != 2 {
+		t.Fail()
+	}
+
+	candles, err = client.GetHistoricRates("ALGO-USD", cb.GetHistoricRatesParams{
+		Start:       time.Unix(1633046400, 0),
+		End:         time.Unix(1633046460, 0),
+		Granularity: 300,
+	})
+	if err != nil || len(candles) != 1 || candles[0].High != 1.2 || candles[0].Close != 1.1 || candles[0].Volume != 200 {
+		t.Fail()
+	}
+
+	if _, err = client.GetHistoricRates("ALGO-USD", cb.GetHistoricRatesParams{Granularity: 120}); err == nil {
+		t.Fail()
+	}
+
+	accounts, err := client.GetAccounts()
+	if err != nil {
+		t.Fail()
+	}
+
+	util.PrettyPrint(accounts)
+}